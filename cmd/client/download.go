@@ -9,22 +9,49 @@ import (
 	"mime"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
 	"github.com/kirillgashkov/assignment-youthumb/proto/youthumbpb/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxFileNameLength is the max length of an output file name without extension.
+const maxFileNameLength = 200
+
+var (
+	// errInvalidFileName is returned when a file name can't be safely used as
+	// an output file name.
+	errInvalidFileName = errors.New("invalid file name")
+	// errInvalidExtension is returned when a file extension can't be safely
+	// used in an output file name.
+	errInvalidExtension = errors.New("invalid file extension")
 )
 
 type thumbnailDownloader struct {
 	cli       youthumbpb.ThumbnailServiceClient
+	providers thumbnail.Providers
 	outputDir string
 	muCh      chan struct{}
 }
 
-func newThumbnailDownloader(cli youthumbpb.ThumbnailServiceClient, outputDir string) *thumbnailDownloader {
-	return &thumbnailDownloader{cli: cli, outputDir: outputDir, muCh: make(chan struct{}, 1)}
+func newThumbnailDownloader(
+	cli youthumbpb.ThumbnailServiceClient,
+	providers thumbnail.Providers,
+	outputDir string,
+) *thumbnailDownloader {
+	return &thumbnailDownloader{cli: cli, providers: providers, outputDir: outputDir, muCh: make(chan struct{}, 1)}
 }
 
 func (d *thumbnailDownloader) DownloadThumbnailForVideoURL(ctx context.Context, videoURL string) error {
+	// Parse the video up front, it is used to name the output file.
+
+	video, err := d.providers.Parse(videoURL)
+	if err != nil {
+		return err
+	}
+
 	// Create a temporary file to store the thumbnail content.
 
 	contentFile, err := os.CreateTemp("", "thumbnail-*")
@@ -67,6 +94,128 @@ func (d *thumbnailDownloader) DownloadThumbnailForVideoURL(ctx context.Context,
 		return err
 	}
 
+	return d.save(ctx, video, contentFile.Name(), contentType)
+}
+
+// DownloadThumbnailsForPlaylistURL downloads thumbnails of the videos of a
+// playlist or a channel. Failures of single videos are logged and don't stop
+// the download.
+func (d *thumbnailDownloader) DownloadThumbnailsForPlaylistURL(ctx context.Context, playlistURL string) error {
+	stream, err := d.cli.GetPlaylistThumbnails(ctx, &youthumbpb.GetPlaylistThumbnailsRequest{PlaylistUrl: playlistURL})
+	if err != nil {
+		return err
+	}
+
+	// Chunks of different videos are never interleaved, so a video is
+	// complete once a chunk of another video arrives.
+	var current *playlistVideoFile
+	defer func() {
+		if current != nil {
+			current.close()
+		}
+	}()
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if current != nil && current.videoURL != chunk.VideoUrl {
+			d.savePlaylistVideo(ctx, current)
+			current = nil
+		}
+
+		if chunk.ErrorCode != 0 {
+			err := status.Error(codes.Code(chunk.ErrorCode), chunk.ErrorMessage)
+			slog.Error("failed to download thumbnail", "video_url", chunk.VideoUrl, "error", err)
+			// The error may follow chunks of the same video whose download
+			// failed midway.
+			if current != nil {
+				current.discard()
+				current = nil
+			}
+			continue
+		}
+
+		if current == nil {
+			current, err = newPlaylistVideoFile(chunk.VideoUrl)
+			if err != nil {
+				return err
+			}
+		}
+		if err := current.write(chunk.Chunk); err != nil {
+			return err
+		}
+	}
+
+	if current != nil {
+		d.savePlaylistVideo(ctx, current)
+		current = nil
+	}
+
+	return nil
+}
+
+// playlistVideoFile is a temporary file with the thumbnail of a playlist video
+// that is being received.
+type playlistVideoFile struct {
+	videoURL    string
+	contentType string
+	file        *os.File
+}
+
+func newPlaylistVideoFile(videoURL string) (*playlistVideoFile, error) {
+	file, err := os.CreateTemp("", "thumbnail-*")
+	if err != nil {
+		return nil, err
+	}
+	return &playlistVideoFile{videoURL: videoURL, file: file}, nil
+}
+
+func (f *playlistVideoFile) write(chunk *youthumbpb.ThumbnailChunk) error {
+	if chunk.GetContentType() != "" {
+		f.contentType = chunk.GetContentType()
+	}
+	_, err := f.file.Write(chunk.GetData())
+	return err
+}
+
+func (f *playlistVideoFile) close() {
+	if err := f.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		slog.Error("failed to close file", "error", err)
+	}
+}
+
+// discard closes and removes a partially received file.
+func (f *playlistVideoFile) discard() {
+	f.close()
+	if err := os.Remove(f.file.Name()); err != nil {
+		slog.Error("failed to remove file", "error", err)
+	}
+}
+
+// savePlaylistVideo moves a received playlist video thumbnail to the output
+// directory. Errors are logged.
+func (d *thumbnailDownloader) savePlaylistVideo(ctx context.Context, f *playlistVideoFile) {
+	f.close()
+
+	video, err := d.providers.Parse(f.videoURL)
+	if err != nil {
+		slog.Error("failed to parse video URL", "video_url", f.videoURL, "error", err)
+		return
+	}
+
+	if err := d.save(ctx, video, f.file.Name(), f.contentType); err != nil {
+		slog.Error("failed to save thumbnail", "video_url", f.videoURL, "error", err)
+	}
+}
+
+// save moves a downloaded thumbnail file to the output directory.
+func (d *thumbnailDownloader) save(ctx context.Context, video thumbnail.Video, contentFileName, contentType string) error {
 	// Determine the extension of the thumbnail file.
 
 	extension := ""
@@ -91,14 +240,12 @@ func (d *thumbnailDownloader) DownloadThumbnailForVideoURL(ctx context.Context,
 				<-d.muCh
 			}()
 
-			videoID, err := thumbnail.ParseVideoID(videoURL)
+			outputFilePath, err := outputFilePath(d.outputDir, videoFileName(video), extension)
 			if err != nil {
-				slog.Error("failed to parse video ID", "video_url", videoURL, "error", err)
+				slog.Error("failed to build output file path", "video", video.Key(), "error", err)
 				return
 			}
 
-			outputFilePath := filepath.Join(d.outputDir, videoID+extension)
-
 			if err := os.MkdirAll(d.outputDir, 0755); err != nil {
 				slog.Error("failed to create directory", "output_dir", d.outputDir, "error", err)
 				return
@@ -106,8 +253,8 @@ func (d *thumbnailDownloader) DownloadThumbnailForVideoURL(ctx context.Context,
 
 			// Copying the file instead of renaming it to avoid cross-device
 			// link errors.
-			if err := copyFile(contentFile.Name(), outputFilePath); err != nil {
-				slog.Error("failed to copy file", "src", contentFile.Name(), "dst", outputFilePath, "error", err)
+			if err := copyFile(contentFileName, outputFilePath); err != nil {
+				slog.Error("failed to copy file", "src", contentFileName, "dst", outputFilePath, "error", err)
 				return
 			}
 		}()
@@ -118,6 +265,60 @@ func (d *thumbnailDownloader) DownloadThumbnailForVideoURL(ctx context.Context,
 	return nil
 }
 
+// videoFileName returns the output file name of a video without extension.
+// YouTube videos are named by their IDs, videos of other providers are
+// prefixed by the provider name. Characters that are not safe in file names are
+// replaced by dashes.
+func videoFileName(video thumbnail.Video) string {
+	name := video.ID
+	if video.Provider.Name() != "youtube" {
+		name = video.Provider.Name() + "-" + video.ID
+	}
+	return strings.Map(func(r rune) rune {
+		if isFileNameChar(r) {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// outputFilePath returns the path of the thumbnail file with the given name and
+// extension. It guarantees that the path is a direct child of outputDir, so a
+// malformed name or extension can't be used for path traversal.
+func outputFilePath(outputDir, name, extension string) (string, error) {
+	if err := validateFileName(name); err != nil {
+		return "", err
+	}
+	if extension != "" && (!strings.HasPrefix(extension, ".") || strings.ContainsAny(extension, "/\\\x00")) {
+		return "", fmt.Errorf("%w: %q", errInvalidExtension, extension)
+	}
+
+	p := filepath.Join(outputDir, name+extension)
+	if filepath.Dir(p) != filepath.Clean(outputDir) {
+		return "", fmt.Errorf("output file path %q escapes output directory %q", p, outputDir)
+	}
+	return p, nil
+}
+
+// validateFileName checks that a file name is non-empty, doesn't start with a
+// dot and consists of [A-Za-z0-9._-] only.
+func validateFileName(name string) error {
+	if name == "" || len(name) > maxFileNameLength || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: %q", errInvalidFileName, name)
+	}
+	for _, r := range name {
+		if !isFileNameChar(r) {
+			return fmt.Errorf("%w: %q", errInvalidFileName, name)
+		}
+	}
+	return nil
+}
+
+// isFileNameChar reports whether r is allowed in output file names.
+func isFileNameChar(r rune) bool {
+	return 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.'
+}
+
 // copyFile copies a file from src to dst.
 // If the dst file exists, it will be overwritten.
 func copyFile(src, dst string) error {
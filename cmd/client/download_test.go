@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestOutputFilePath(t *testing.T) {
+	tests := []struct {
+		name      string
+		outputDir string
+		fileName  string
+		extension string
+		want      string
+		wantErr   bool
+	}{
+		{name: "valid", outputDir: "results", fileName: "dQw4w9WgXcQ", extension: ".jpg", want: filepath.Join("results", "dQw4w9WgXcQ.jpg")},
+		{name: "no extension", outputDir: "results", fileName: "dQw4w9WgXcQ", want: filepath.Join("results", "dQw4w9WgXcQ")},
+		{name: "unclean output dir", outputDir: "./a/../results/", fileName: "dQw4w9WgXcQ", extension: ".jpg", want: filepath.Join("results", "dQw4w9WgXcQ.jpg")},
+		{name: "provider prefix", outputDir: "results", fileName: "vimeo-76979871", extension: ".jpg", want: filepath.Join("results", "vimeo-76979871.jpg")},
+		{name: "path traversal name", outputDir: "results", fileName: "../../x", extension: ".jpg", wantErr: true},
+		{name: "dot dot name", outputDir: "results", fileName: "..", wantErr: true},
+		{name: "hidden file", outputDir: "results", fileName: ".bashrc", wantErr: true},
+		{name: "empty name", outputDir: "results", fileName: "", extension: ".jpg", wantErr: true},
+		{name: "extension with separator", outputDir: "results", fileName: "dQw4w9WgXcQ", extension: "/../../x", wantErr: true},
+		{name: "extension without dot", outputDir: "results", fileName: "dQw4w9WgXcQ", extension: "jpg", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := outputFilePath(tt.outputDir, tt.fileName, tt.extension)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("outputFilePath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("outputFilePath() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVideoFileName(t *testing.T) {
+	providers, err := thumbnail.NewProviders(&config.Config{PeerTube: config.PeerTubeConfig{Instances: []string{"https://framatube.org"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		videoURL string
+		want     string
+	}{
+		{name: "youtube", videoURL: "https://youtu.be/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "vimeo", videoURL: "https://vimeo.com/76979871", want: "vimeo-76979871"},
+		{name: "dailymotion", videoURL: "https://dai.ly/x7tgad0", want: "dailymotion-x7tgad0"},
+		{
+			name:     "peertube",
+			videoURL: "https://framatube.org/w/9c9de5e8-0a1e-484a-b099-e80766180a6d",
+			want:     "peertube-framatube.org-9c9de5e8-0a1e-484a-b099-e80766180a6d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video, err := providers.Parse(tt.videoURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := videoFileName(video); got != tt.want {
+				t.Errorf("videoFileName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzOutputFilePath(f *testing.F) {
+	f.Add("https://www.youtube.com/watch?v=dQw4w9WgXcQ", ".jpg")
+	f.Add("https://youtu.be/../../x", ".jpg")
+	f.Add("https://youtu.be/dQw4w9WgXcQ", "/../../x")
+	f.Add("youtu.be/%2E%2E%2Fx", "")
+	f.Add("https://vimeo.com/76979871", ".jpg")
+	f.Add("https://framatube.org/w/..%2F..%2Fx", ".png")
+
+	providers, err := thumbnail.NewProviders(&config.Config{PeerTube: config.PeerTubeConfig{Instances: []string{"https://framatube.org"}}})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	const outputDir = "results"
+
+	f.Fuzz(func(t *testing.T, videoURL, extension string) {
+		video, err := providers.Parse(videoURL)
+		if err != nil {
+			return
+		}
+
+		name := videoFileName(video)
+		p, err := outputFilePath(outputDir, name, extension)
+		if err != nil {
+			return
+		}
+
+		if filepath.Dir(p) != outputDir {
+			t.Fatalf("outputFilePath(%q, %q) = %q, which is outside of %q", name, extension, p, outputDir)
+		}
+		if !strings.HasPrefix(filepath.Base(p), name) {
+			t.Fatalf("outputFilePath(%q, %q) = %q, which is not named after the video", name, extension, p)
+		}
+	})
+}
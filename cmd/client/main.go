@@ -11,11 +11,13 @@ import (
 	"github.com/kirillgashkov/assignment-youthumb/internal/app/log"
 
 	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
 )
 
 var (
-	isAsync   = flag.Bool("async", false, "Download thumbnails asynchronously.")
-	outputDir = flag.String("o", "", "Path to the output directory.")
+	isAsync    = flag.Bool("async", false, "Download thumbnails asynchronously.")
+	isPlaylist = flag.Bool("playlist", false, "Treat the URLs as playlist or channel URLs and download thumbnails of their videos.")
+	outputDir  = flag.String("o", "", "Path to the output directory.")
 )
 
 func main() {
@@ -86,7 +88,16 @@ func mainErr() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	downloader := newThumbnailDownloader(cli, *outputDir)
+	providers, err := thumbnail.NewProviders(cfg)
+	if err != nil {
+		return err
+	}
+
+	downloader := newThumbnailDownloader(cli, providers, *outputDir)
+	download := downloader.DownloadThumbnailForVideoURL
+	if *isPlaylist {
+		download = downloader.DownloadThumbnailsForPlaylistURL
+	}
 
 	if *isAsync {
 		func() {
@@ -97,7 +108,7 @@ func mainErr() error {
 				wg.Add(1)
 				go func() { // https://go.dev/blog/loopvar-preview
 					defer wg.Done()
-					if err := downloader.DownloadThumbnailForVideoURL(ctx, videoURL); err != nil {
+					if err := download(ctx, videoURL); err != nil {
 						slog.Error("failed to download thumbnail", "video_url", videoURL, "error", err)
 					}
 				}()
@@ -105,7 +116,7 @@ func mainErr() error {
 		}()
 	} else {
 		for _, videoURL := range videoURLs {
-			if err := downloader.DownloadThumbnailForVideoURL(ctx, videoURL); err != nil {
+			if err := download(ctx, videoURL); err != nil {
 				slog.Error("failed to download thumbnail", "video_url", videoURL, "error", err)
 			}
 		}
@@ -117,15 +128,17 @@ func mainErr() error {
 func usage() {
 	u := fmt.Sprintf(`Usage: %s [OPTIONS] [FILE_WITH_VIDEO_URLS...]
 
-A client for downloading thumbnails for YouTube videos with the specified URLs.
+A client for downloading thumbnails for YouTube, Vimeo, Dailymotion and PeerTube
+videos with the specified URLs.
 
 gRPC server address is specified via the environment variables APP_GRPC_HOST and
-APP_GRPC_PORT.
+APP_GRPC_PORT. Supported PeerTube instances are specified via APP_PEERTUBE_INSTANCES.
 
 Arguments:
   FILE_WITH_VIDEO_URLS
-		Path to a file with new-line separated YouTube video URLs. If no files
-		are provided, the URLs are read from the standard input.
+		Path to a file with new-line separated video URLs (or playlist and
+		channel URLs with -playlist). If no files are provided, the URLs are
+		read from the standard input.
 
 Options:
 `, os.Args[0])
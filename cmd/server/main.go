@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
 	"github.com/kirillgashkov/assignment-youthumb/internal/app/log"
@@ -14,7 +23,10 @@ import (
 )
 
 var (
-	dsn = flag.String("d", ":memory:", "Path to the SQLite database.")
+	dsn           = flag.String("d", ":memory:", "Path to the SQLite database or a Postgres URL (postgres://...).")
+	clearNotFound = flag.Bool("clear-not-found", false, "Clear the videos cached as not found in the database given by -d and in Redis and exit. Requires -d unless Redis is the standalone cache. The in-memory caches of running servers are not cleared.")
+	migrateOnly   = flag.Bool("migrate-only", false, "Migrate the database schema and exit.")
+	migrateDryRun = flag.Bool("migrate-dry-run", false, "Print the pending database schema migrations and exit.")
 )
 
 func main() {
@@ -47,19 +59,77 @@ func mainErr() error {
 
 	// Prepare cache.
 
-	cache, err := thumbnail.OpenCache(*dsn)
+	// The default in-memory database is private to this process, so there is
+	// nothing to clear in it. The standalone Redis cache has no database.
+	if *clearNotFound && usesDatabase(cfg) && !isFlagSet("d") {
+		return errors.New("-clear-not-found requires -d")
+	}
+	if (*migrateOnly || *migrateDryRun) && !usesDatabase(cfg) {
+		return errors.New("the standalone Redis cache has no database to migrate")
+	}
+
+	if *migrateDryRun {
+		pending, version, err := pendingMigrations(*dsn)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			if _, err := fmt.Printf("%d %s\n", m.Version, m.Description); err != nil {
+				return err
+			}
+		}
+		slog.Info("found pending migrations", "count", len(pending), "schema_version", version)
+		return nil
+	}
+
+	sharedCache, sweeper, err := openSharedCache(*dsn, cfg)
 	if err != nil {
 		return err
 	}
-	defer func(cache *thumbnail.Cache) {
+	cache := newCache(sharedCache, &cfg.Cache)
+	defer func(cache thumbnail.Cache) {
 		if err := cache.Close(); err != nil {
 			slog.Error("failed to close cache", "error", err)
 		}
 	}(cache)
 
+	if *migrateOnly {
+		version := thumbnail.SchemaVersion
+		if isPostgresDSN(*dsn) {
+			version = thumbnail.PostgresSchemaVersion
+		}
+		slog.Info("migrated database", "schema_version", version)
+		if sqliteCache, ok := sweeper.(*thumbnail.SQLiteCache); ok {
+			converted, err := sqliteCache.EnableIncrementalVacuum()
+			if err != nil {
+				return err
+			}
+			if converted {
+				slog.Info("enabled incremental auto-vacuum")
+			}
+		}
+		return nil
+	}
+
+	if *clearNotFound {
+		n, err := cache.ClearNotFound()
+		if err != nil {
+			return err
+		}
+		slog.Info("cleared videos cached as not found", "count", n)
+		return nil
+	}
+
 	// Create and start the server.
 
-	srv := rpc.NewServer(cache, cfg)
+	srv, err := rpc.NewServer(cache, cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Debug.Addr != "" {
+		go serveDebug(cfg.Debug.Addr)
+	}
 
 	addr := &net.TCPAddr{IP: net.ParseIP(cfg.GRPC.Host), Port: cfg.GRPC.Port}
 	lis, err := net.ListenTCP("tcp", addr)
@@ -67,19 +137,194 @@ func mainErr() error {
 		return err
 	}
 
+	// The background jobs are stopped before the cache is closed.
+	var jobs sync.WaitGroup
+	defer jobs.Wait()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if sweeper != nil && cfg.Cache.JanitorInterval > 0 {
+		janitor := thumbnail.NewJanitor(sweeper)
+		janitor.Interval = cfg.Cache.JanitorInterval
+		janitor.BatchSize = cfg.Cache.JanitorBatchSize
+		janitor.Retention = max(cfg.Cache.StaleWhileRevalidate, cfg.Cache.StaleIfError)
+		janitor.Vacuum = cfg.Cache.JanitorVacuum
+		janitor.BlobGracePeriod = cfg.Cache.BlobGracePeriod
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			janitor.Run(ctx)
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		slog.Info("stopping server")
+		srv.GracefulStop()
+	}()
+
 	slog.Info("starting server", "addr", addr, "mode", cfg.Mode)
 	err = srv.Serve(lis)
 	return err
 }
 
+// persistentCache is a database cache whose expired entries are swept by the
+// janitor.
+type persistentCache interface {
+	thumbnail.Cache
+	thumbnail.Sweeper
+}
+
+// isPostgresDSN reports whether a DSN is a Postgres URL rather than a SQLite
+// DSN.
+func isPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// openSharedCache opens the caches shared between replicas: the Redis cache
+// in front of the database cache or either of them alone. It also returns the
+// database cache to be swept by the janitor, nil if Redis is standalone.
+func openSharedCache(dsn string, cfg *config.Config) (thumbnail.Cache, thumbnail.Sweeper, error) {
+	if cfg.Redis.URL == "" {
+		cache, err := openCache(dsn, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cache, cache, nil
+	}
+
+	redisCache, err := thumbnail.OpenRedisCache(&cfg.Redis)
+	if err != nil {
+		return nil, nil, err
+	}
+	redisCache.Retention = max(cfg.Cache.StaleWhileRevalidate, cfg.Cache.StaleIfError)
+	if cfg.Redis.Standalone {
+		return redisCache, nil, nil
+	}
+
+	cache, err := openCache(dsn, cfg)
+	if err != nil {
+		return nil, nil, errors.Join(err, redisCache.Close())
+	}
+	return thumbnail.NewTieredCache(redisCache, cache), cache, nil
+}
+
+// openCache opens the database cache selected by the scheme of a DSN.
+func openCache(dsn string, cfg *config.Config) (persistentCache, error) {
+	if !isPostgresDSN(dsn) {
+		return openSQLiteCache(dsn, cfg)
+	}
+
+	// Replicas sharing the database must share the blob store too.
+	if cfg.Cache.BlobDir != "" {
+		return nil, fmt.Errorf("blob directory is not supported by the Postgres cache, use S3")
+	}
+	cache, err := thumbnail.OpenPostgresCache(dsn, &cfg.Postgres)
+	if err != nil {
+		return nil, err
+	}
+	cache.MaxSize = cfg.Cache.MaxSize
+	cache.MaxEntries = cfg.Cache.MaxEntries
+	cache.Eviction = cfg.Cache.Eviction
+	if cfg.S3.Endpoint != "" {
+		blobs, err := thumbnail.OpenS3BlobStore(&cfg.S3)
+		if err != nil {
+			return nil, errors.Join(err, cache.Close())
+		}
+		cache.Blobs = blobs
+	}
+	return cache, nil
+}
+
+// pendingMigrations returns the pending migrations of the database of a DSN
+// and the schema version they lead to.
+func pendingMigrations(dsn string) ([]thumbnail.Migration, int, error) {
+	if isPostgresDSN(dsn) {
+		pending, err := thumbnail.PendingPostgresMigrations(dsn)
+		return pending, thumbnail.PostgresSchemaVersion, err
+	}
+	pending, err := thumbnail.PendingMigrations(dsn)
+	return pending, thumbnail.SchemaVersion, err
+}
+
+// openSQLiteCache opens the size-bounded SQLite cache that stores thumbnail
+// data in the blob directory or in S3 if either is set.
+func openSQLiteCache(dsn string, cfg *config.Config) (*thumbnail.SQLiteCache, error) {
+	cache, err := thumbnail.OpenSQLiteCache(dsn, &cfg.SQLite)
+	if err != nil {
+		return nil, err
+	}
+	cache.MaxSize = cfg.Cache.MaxSize
+	cache.MaxEntries = cfg.Cache.MaxEntries
+	cache.Eviction = cfg.Cache.Eviction
+	switch {
+	case cfg.Cache.BlobDir != "":
+		blobs, err := thumbnail.NewFileBlobStore(cfg.Cache.BlobDir)
+		if err != nil {
+			return nil, errors.Join(err, cache.Close())
+		}
+		cache.Blobs = blobs
+	case cfg.S3.Endpoint != "":
+		blobs, err := thumbnail.OpenS3BlobStore(&cfg.S3)
+		if err != nil {
+			return nil, errors.Join(err, cache.Close())
+		}
+		cache.Blobs = blobs
+	}
+	return cache, nil
+}
+
+// newCache layers the in-memory cache in front of the shared cache unless the
+// in-memory cache is disabled.
+func newCache(cache thumbnail.Cache, cfg *config.CacheConfig) thumbnail.Cache {
+	if cfg.MemorySize == 0 {
+		return cache
+	}
+	return thumbnail.NewTieredCache(thumbnail.NewMemoryCache(cfg.MemorySize), cache)
+}
+
+// serveDebug serves runtime metrics, e.g. the states of the upstream circuit
+// breakers, at /debug/vars.
+func serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	slog.Info("starting debug server", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil {
+		slog.Error("debug server failed", "error", err)
+	}
+}
+
+// usesDatabase reports whether the server caches thumbnails in the database
+// given by -d, i.e. unless Redis is the standalone cache.
+func usesDatabase(cfg *config.Config) bool {
+	return cfg.Redis.URL == "" || !cfg.Redis.Standalone
+}
+
+// isFlagSet reports whether a flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func usage() {
 	u := fmt.Sprintf(`Usage: %s [OPTIONS]
 
-A server for proxying YouTube video thumbnails. It downloads thumbnails from
-YouTube, caches them in a SQLite database and serves them via gRPC.
+A server for proxying video thumbnails. It downloads thumbnails from YouTube,
+Vimeo, Dailymotion and PeerTube, caches them in a SQLite or Postgres database,
+optionally with the image data in S3-compatible object storage, and serves
+them via gRPC.
 
 gRPC server listening address is configured via the APP_GRPC_HOST and
-APP_GRPC_PORT.
+APP_GRPC_PORT. Supported PeerTube instances are configured via the
+APP_PEERTUBE_INSTANCES as a comma-separated list of base URLs. Runtime metrics
+are served at /debug/vars on APP_DEBUG_ADDR if it is set.
 
 Options:
 `, os.Args[0])
@@ -0,0 +1,31 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+)
+
+func TestNewBlobGracePeriod(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "default"},
+		{name: "positive", value: "10m"},
+		{name: "zero", value: "0s", wantErr: true},
+		{name: "negative", value: "-1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != "" {
+				t.Setenv("APP_CACHE_BLOB_GRACE_PERIOD", tt.value)
+			}
+			if _, err := config.New(); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package rpc
 
 import (
+	"fmt"
+
 	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
 	"github.com/kirillgashkov/assignment-youthumb/internal/rpc/interceptor"
 	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
@@ -10,7 +12,20 @@ import (
 )
 
 // NewServer creates a new gRPC server.
-func NewServer(cache *thumbnail.Cache, cfg *config.Config) *grpc.Server {
+func NewServer(cache thumbnail.Cache, cfg *config.Config) (*grpc.Server, error) {
+	providers, err := thumbnail.NewProviders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := thumbnail.NewFetcher(&cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	playlistResolver, err := newPlaylistResolver(&cfg.Playlist)
+	if err != nil {
+		return nil, err
+	}
+
 	srv := grpc.NewServer(
 		grpc.ChainUnaryInterceptor(
 			interceptor.NewUnaryServerLog(),
@@ -25,7 +40,31 @@ func NewServer(cache *thumbnail.Cache, cfg *config.Config) *grpc.Server {
 	if cfg.Mode == config.ModeDevelopment {
 		reflection.Register(srv)
 	}
-	youthumbpb.RegisterThumbnailServiceServer(srv, thumbnail.NewService(cache))
+	service := thumbnail.NewService(cache, providers, playlistResolver, fetcher)
+	service.Freshness = thumbnail.FreshnessPolicy{
+		MinTTL:     cfg.Cache.MinTTL,
+		MaxTTL:     cfg.Cache.MaxTTL,
+		DefaultTTL: cfg.Cache.DefaultTTL,
+	}
+	service.MaxThumbnailSize = cfg.Upstream.MaxBodySize
+	service.StaleWhileRevalidate = cfg.Cache.StaleWhileRevalidate
+	service.StaleIfError = cfg.Cache.StaleIfError
+	service.NotFoundTTL = cfg.Cache.NotFoundTTL
+	service.DownloadTimeout = cfg.Upstream.DownloadTimeout
+	youthumbpb.RegisterThumbnailServiceServer(srv, service)
+
+	return srv, nil
+}
 
-	return srv
+// newPlaylistResolver creates the playlist resolver selected by the config.
+func newPlaylistResolver(cfg *config.PlaylistConfig) (thumbnail.PlaylistResolver, error) {
+	switch cfg.Resolver {
+	case config.PlaylistResolverFeed:
+		return &thumbnail.FeedPlaylistResolver{URL: cfg.FeedURL, MaxVideos: cfg.MaxVideos}, nil
+	case config.PlaylistResolverAPI:
+		return &thumbnail.APIPlaylistResolver{URL: cfg.APIURL, Key: cfg.APIKey, MaxVideos: cfg.MaxVideos}, nil
+	case config.PlaylistResolverEndpoint:
+		return &thumbnail.EndpointPlaylistResolver{URL: cfg.EndpointURL, MaxVideos: cfg.MaxVideos}, nil
+	}
+	return nil, fmt.Errorf("invalid playlist resolver: %s", cfg.Resolver)
 }
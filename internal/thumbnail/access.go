@@ -0,0 +1,118 @@
+package thumbnail
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// accessFlushInterval is the time between flushes of the accesses
+	// buffered by an accessLog.
+	accessFlushInterval = time.Second
+	// maxBufferedAccesses is the number of accessed thumbnails after which an
+	// accessLog is flushed before its interval elapses.
+	maxBufferedAccesses = 10000
+)
+
+// access is the accesses of a thumbnail buffered by an accessLog.
+type access struct {
+	// hits is the number of accesses.
+	hits int64
+	// at is the time of the last access in Unix milliseconds.
+	at int64
+}
+
+// accessLog buffers the accesses of thumbnails, so that caches that track them
+// for eviction record them in batches in the background rather than with a
+// write per read. Recording accesses is best-effort: accesses that fail to
+// flush are logged and dropped.
+type accessLog struct {
+	// write records a batch of accesses by video ID.
+	write func(map[string]access) error
+
+	mu       sync.Mutex
+	accesses map[string]access
+	full     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newAccessLog creates a new access log that records batches of accesses by a
+// given function every accessFlushInterval until it's closed.
+func newAccessLog(write func(map[string]access) error) *accessLog {
+	l := &accessLog{
+		write:    write,
+		accesses: make(map[string]access),
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// run flushes the log every accessFlushInterval and once it's full until the
+// log is closed.
+func (l *accessLog) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(accessFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.full:
+		case <-l.stop:
+			return
+		}
+		if err := l.flush(); err != nil {
+			slog.Error("failed to record thumbnail accesses", "error", err)
+		}
+	}
+}
+
+// record buffers an access of a thumbnail at a given time.
+func (l *accessLog) record(videoID string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a := l.accesses[videoID]
+	a.hits++
+	a.at = max(a.at, at.UnixMilli())
+	l.accesses[videoID] = a
+
+	if len(l.accesses) >= maxBufferedAccesses {
+		select {
+		case l.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take removes the buffered accesses from the log and returns them.
+func (l *accessLog) take() map[string]access {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	accesses := l.accesses
+	l.accesses = make(map[string]access)
+	return accesses
+}
+
+// flush records the buffered accesses.
+func (l *accessLog) flush() error {
+	accesses := l.take()
+	if len(accesses) == 0 {
+		return nil
+	}
+	return l.write(accesses)
+}
+
+// close stops the background flushes and flushes the remaining accesses.
+func (l *accessLog) close() error {
+	close(l.stop)
+	<-l.done
+	return l.flush()
+}
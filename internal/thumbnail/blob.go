@@ -0,0 +1,227 @@
+package thumbnail
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BlobStore is a content-addressed store of thumbnail data, see FileBlobStore
+// and S3BlobStore. A blob is named by the hex-encoded SHA-256 hash of its
+// content, so identical thumbnails are stored once.
+type BlobStore interface {
+	// Put stores the data of a thumbnail and returns its hash. Writing a blob
+	// that already exists refreshes its modification time, so that it isn't
+	// collected as an orphan before it's referenced again.
+	Put(t *Thumbnail) (string, error)
+	// Open returns a reader of the data of a blob, which the caller must
+	// close. The blob is read until the context is done. If the blob doesn't
+	// exist, it returns an error wrapping fs.ErrNotExist.
+	Open(ctx context.Context, hash string) (io.ReadCloser, error)
+	// Collect deletes the blobs modified before a given time for which
+	// referenced returns false and returns their number and total size. It
+	// stops once the context is done.
+	Collect(ctx context.Context, before time.Time, referenced func(hash string) bool) (int64, int64, error)
+}
+
+// blobTempDir is the directory of a FileBlobStore where blobs are written
+// before they are moved into place.
+const blobTempDir = "tmp"
+
+// FileBlobStore is a BlobStore in a directory tree. A blob is stored at
+// "ab/cd/abcd..." by its hash. The content type and the expiration of
+// thumbnails aren't stored, the database keeps them.
+//
+// Blobs are written to a temporary file and renamed into place, so a blob is
+// either complete or absent.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore creates a new blob store in a given directory. The
+// directory is created if it doesn't exist.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, blobTempDir), 0o755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+// Put implements BlobStore. If the blob already exists, only its modification
+// time is updated.
+func (s *FileBlobStore) Put(t *Thumbnail) (string, error) {
+	hash := blobHash(t.Data)
+	path := s.path(hash)
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return hash, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Join(s.dir, blobTempDir), "blob-*")
+	if err != nil {
+		return "", err
+	}
+	// The temporary file is removed unless it's renamed.
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(t.Data); err != nil {
+		return "", errors.Join(err, f.Close())
+	}
+	if err := f.Sync(); err != nil {
+		return "", errors.Join(err, f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Open implements BlobStore.
+func (s *FileBlobStore) Open(_ context.Context, hash string) (io.ReadCloser, error) {
+	if !isBlobHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash: %q", hash)
+	}
+	return os.Open(s.path(hash))
+}
+
+// Collect implements BlobStore. It also deletes the temporary files left by
+// interrupted writes.
+//
+// Blobs written after referenced was captured are newer than the given time,
+// so they aren't deleted before their references are committed. The
+// modification time of a blob is checked again right before it's deleted,
+// since a blob may be written again while the directory tree is walked.
+func (s *FileBlobStore) Collect(ctx context.Context, before time.Time, referenced func(hash string) bool) (int64, int64, error) {
+	var n, size int64
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(before) {
+			return nil
+		}
+		isBlob := filepath.Dir(path) != filepath.Join(s.dir, blobTempDir)
+		if isBlob && (!isBlobHash(d.Name()) || referenced(d.Name())) {
+			return nil
+		}
+
+		if info, err = os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if isBlob {
+			n++
+			size += info.Size()
+		}
+		return nil
+	})
+	return n, size, err
+}
+
+// path returns the path of a blob.
+func (s *FileBlobStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[0:2], hash[2:4], hash)
+}
+
+// blobHash returns the hash that names the blob of data.
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// openBlob opens the blob of the thumbnail of a video. A missing blob is
+// logged and not found, so that the thumbnail is downloaded again.
+func openBlob(ctx context.Context, blobs BlobStore, videoID, hash string) (io.ReadCloser, error) {
+	if blobs == nil {
+		return nil, fmt.Errorf("thumbnail %s is stored in a blob store, but none is configured", videoID)
+	}
+	body, err := blobs.Open(ctx, hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("thumbnail blob is missing", "video_id", videoID, "hash", hash)
+		return nil, ErrNotFound
+	}
+	return body, err
+}
+
+// readBlob reads the blob of the thumbnail of a video, see openBlob.
+func readBlob(blobs BlobStore, videoID, hash string) ([]byte, error) {
+	body, err := openBlob(context.Background(), blobs, videoID, hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	return data, errors.Join(err, body.Close())
+}
+
+// collectBlobs deletes the blobs of a blob store modified before a given time
+// that no thumbnail in the cache table of a database references.
+func collectBlobs(ctx context.Context, db *sql.DB, blobs BlobStore, before time.Time) (int64, int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT blob_hash FROM cache WHERE blob_hash != ''`)
+	if err != nil {
+		return 0, 0, err
+	}
+	referenced := make(map[string]struct{})
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return 0, 0, errors.Join(err, rows.Close())
+		}
+		referenced[hash] = struct{}{}
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return 0, 0, err
+	}
+
+	return blobs.Collect(ctx, before, func(hash string) bool {
+		_, ok := referenced[hash]
+		return ok
+	})
+}
+
+// isBlobHash reports whether s is a hex-encoded SHA-256 hash.
+func isBlobHash(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
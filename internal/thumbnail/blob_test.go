@@ -0,0 +1,193 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := thumbnail.NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := blobs.Put(&thumbnail.Thumbnail{Data: []byte("jpeg")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "41e5787e9f28562d07b891b1816b492309d646c0f2829743fa4963a9f9cc1d61"
+	if hash != want {
+		t.Errorf("Put() = %q, want %q", hash, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, want[0:2], want[2:4], want)); err != nil {
+		t.Errorf("Put() didn't store the blob by its hash: %v", err)
+	}
+	if again, err := blobs.Put(&thumbnail.Thumbnail{Data: []byte("jpeg")}); err != nil || again != hash {
+		t.Errorf("Put() = %q, %v, want %q", again, err, hash)
+	}
+
+	got, err := readBlob(blobs, hash)
+	if err != nil || !bytes.Equal(got, []byte("jpeg")) {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "jpeg")
+	}
+	for _, hash := range []string{"../../../etc/passwd", "missing"} {
+		if _, err := readBlob(blobs, hash); err == nil {
+			t.Errorf("Get(%q) error = nil, want an error", hash)
+		}
+	}
+
+	orphan, err := blobs.Put(&thumbnail.Thumbnail{Data: []byte("png")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := filepath.Join(dir, "tmp", "blob-interrupted")
+	if err := os.WriteFile(temp, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs newer than the given time are kept regardless.
+	referenced := func(h string) bool { return h == hash }
+	if n, size, err := blobs.Collect(context.Background(), time.Now().Add(-time.Hour), referenced); err != nil || n != 0 || size != 0 {
+		t.Errorf("Collect() = %d, %d, %v, want nothing collected", n, size, err)
+	}
+	n, size, err := blobs.Collect(context.Background(), time.Now().Add(time.Hour), referenced)
+	if err != nil || n != 1 || size != int64(len("png")) {
+		t.Errorf("Collect() = %d, %d, %v, want 1 blob of %d bytes", n, size, err, len("png"))
+	}
+	if _, err := readBlob(blobs, orphan); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get() error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := readBlob(blobs, hash); err != nil {
+		t.Errorf("Get() error = %v, want the referenced blob kept", err)
+	}
+	if _, err := os.Stat(temp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() error = %v, want the temporary file deleted", err)
+	}
+}
+
+func TestFileBlobStoreCollectWrittenAgain(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := thumbnail.NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := &thumbnail.Thumbnail{Data: []byte("jpeg")}
+	hash, err := blobs.Put(orphan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, hash[0:2], hash[2:4], hash), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// The orphan is written again and referenced by a thumbnail committed
+	// after the references were captured, but before the blob is deleted.
+	referenced := func(string) bool {
+		if _, err := blobs.Put(orphan); err != nil {
+			t.Fatal(err)
+		}
+		return false
+	}
+	if n, size, err := blobs.Collect(context.Background(), time.Now().Add(-time.Hour), referenced); err != nil || n != 0 || size != 0 {
+		t.Errorf("Collect() = %d, %d, %v, want nothing collected", n, size, err)
+	}
+	if _, err := readBlob(blobs, hash); err != nil {
+		t.Errorf("Get() error = %v, want the blob written again kept", err)
+	}
+}
+
+func TestSQLiteCacheBlobs(t *testing.T) {
+	cache, err := thumbnail.OpenSQLiteCache(filepath.Join(t.TempDir(), "cache.db"), &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	// A thumbnail stored in the database is read after a blob store is set.
+	if err := cache.SetThumbnail("inline", &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("inline")}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cache.Blobs, err = thumbnail.NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, videoID := range []string{"a", "b"} {
+		if err := cache.SetThumbnail(videoID, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("same")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for videoID, want := range map[string]string{"inline": "inline", "a": "same", "b": "same"} {
+		got, err := cache.GetThumbnail(videoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Data) != want {
+			t.Errorf("GetThumbnail(%q) data = %q, want %q", videoID, got.Data, want)
+		}
+	}
+	if n := countBlobs(t, dir); n != 1 {
+		t.Errorf("got %d blobs, want identical thumbnails stored once", n)
+	}
+
+	// Replacing both thumbnails orphans the shared blob.
+	for _, videoID := range []string{"a", "b"} {
+		if err := cache.SetThumbnail(videoID, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte(videoID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, _, err := cache.CollectBlobs(context.Background(), time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("CollectBlobs() = %d, %v, want 1", n, err)
+	}
+	if n := countBlobs(t, dir); n != 2 {
+		t.Errorf("got %d blobs, want 2", n)
+	}
+
+	// A thumbnail whose blob is gone is a miss.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetThumbnail("a"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+}
+
+// countBlobs returns the number of blobs in a blob store directory.
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	var n int
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Base(filepath.Dir(path)) != "tmp" {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// readBlob reads a blob of a blob store.
+func readBlob(blobs thumbnail.BlobStore, hash string) ([]byte, error) {
+	body, err := blobs.Open(context.Background(), hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	return data, errors.Join(err, body.Close())
+}
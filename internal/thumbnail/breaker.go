@@ -0,0 +1,197 @@
+package thumbnail
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen is returned when requests to an upstream host are
+	// rejected because its circuit breaker is open, see CircuitOpenError.
+	ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+)
+
+var (
+	// breakerStates exposes the current state of the circuit breaker of every
+	// upstream host at /debug/vars.
+	breakerStates = expvar.NewMap("upstream_circuit_breaker_states")
+	// breakerTransitions exposes the number of transitions of circuit breakers
+	// into every state at /debug/vars.
+	breakerTransitions = expvar.NewMap("upstream_circuit_breaker_transitions")
+)
+
+// CircuitOpenError is returned when requests to an upstream host are rejected
+// because its circuit breaker is open. It wraps ErrCircuitOpen.
+type CircuitOpenError struct {
+	Host string
+	// RetryAfter is the time left until the breaker lets a trial request
+	// through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %s: retry after %s", ErrCircuitOpen, e.Host, e.RetryAfter)
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// BreakerState is a state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through and counts consecutive
+	// failures.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests until the open timeout elapses.
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of trial requests through. A
+	// successful trial closes the breaker, a failed one opens it again.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerPolicy is a policy of circuit breakers of upstream hosts. Requests
+// that fail with a network error or a retryable status after all retries count
+// as failures.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker. Zero disables circuit breaking.
+	FailureThreshold int
+	// OpenTimeout is the time the breaker stays open before it lets trial
+	// requests through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the max number of concurrent trial requests in the
+	// half-open state.
+	HalfOpenRequests int
+}
+
+// breaker is a circuit breaker of a single upstream host.
+type breaker struct {
+	host   string
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+// newBreaker creates a new closed circuit breaker of a given host.
+func newBreaker(host string, policy BreakerPolicy) *breaker {
+	b := &breaker{host: host, policy: policy}
+	breakerStates.Set(host, stateVar(BreakerClosed))
+	return b
+}
+
+// allow reports whether a request may be sent. If it may not, it returns the
+// time left until the breaker lets a trial request through. Every allowed
+// request must be followed by exactly one call to done.
+func (b *breaker) allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if left := b.policy.OpenTimeout - time.Since(b.openedAt); left > 0 {
+			return left, false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.trials >= max(b.policy.HalfOpenRequests, 1) {
+			return b.policy.OpenTimeout, false
+		}
+		b.trials++
+	}
+	return 0, true
+}
+
+// breakerOutcome is an outcome of a request allowed by a circuit breaker.
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota
+	breakerFailure
+	// breakerIgnored is an outcome of a request canceled by the caller, it
+	// says nothing about the upstream host.
+	breakerIgnored
+)
+
+// done records the outcome of an allowed request.
+func (b *breaker) done(outcome breakerOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		// The request was allowed before the breaker opened.
+		return
+	case BreakerHalfOpen:
+		b.trials = max(b.trials-1, 0)
+	}
+
+	switch {
+	case outcome == breakerIgnored:
+	case outcome == breakerSuccess:
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+	case b.state == BreakerHalfOpen:
+		b.open()
+	default:
+		b.failures++
+		if b.failures >= b.policy.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+// idle reports whether the breaker is closed and has no failures.
+func (b *breaker) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state == BreakerClosed && b.failures == 0
+}
+
+// open opens the breaker. The caller must hold b.mu.
+func (b *breaker) open() {
+	b.failures = 0
+	b.openedAt = time.Now()
+	b.setState(BreakerOpen)
+}
+
+// setState logs and exposes a state transition. The caller must hold b.mu.
+func (b *breaker) setState(state BreakerState) {
+	slog.Warn("upstream circuit breaker state changed", "host", b.host, "from", b.state, "to", state)
+	b.state = state
+	b.trials = 0
+	breakerStates.Set(b.host, stateVar(state))
+	breakerTransitions.Add(state.String(), 1)
+}
+
+// stateVar returns an expvar value of a breaker state.
+func stateVar(state BreakerState) expvar.Var {
+	v := new(expvar.String)
+	v.Set(state.String())
+	return v
+}
@@ -1,79 +1,53 @@
 package thumbnail
 
 import (
-	"database/sql"
-	"errors"
+	"context"
+	"io"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-// Cache is a cache for thumbnail images.
-type Cache struct {
-	// db is the SQLite database connection pool.
-	db *sql.DB
-}
-
-// OpenCache opens a new cache.
-// The given DSN must be a SQLite DSN.
-func OpenCache(dsn string) (*Cache, error) {
-	db, err := sql.Open("sqlite3", dsn)
-	if err != nil {
-		return nil, err
-	}
-
-	createTableQuery := `
-		CREATE TABLE IF NOT EXISTS cache (
-			video_id TEXT PRIMARY KEY,
-			content_type TEXT NOT NULL,
-			data BLOB NOT NULL,
-			expires_at INTEGER NOT NULL
-		)
-	`
-	if _, err := db.Exec(createTableQuery); err != nil {
-		return nil, err
-	}
-
-	return &Cache{db: db}, nil
+// Cache is a storage of thumbnails and of videos that are not found. Keys are
+// namespaced video keys, see Video.Key.
+type Cache interface {
+	// GetThumbnail returns a thumbnail from the cache. Expired thumbnails are
+	// kept and returned too, see Thumbnail.Expiration.
+	// If the thumbnail is not found in the cache, it returns ErrNotFound.
+	GetThumbnail(videoID string) (*Thumbnail, error)
+	// SetThumbnail sets a thumbnail in the cache.
+	SetThumbnail(videoID string, t *Thumbnail) error
+	// ExtendThumbnail updates the expiration and the validators of a
+	// revalidated thumbnail in the cache without rewriting its data.
+	// If the thumbnail is not found in the cache, it returns ErrNotFound.
+	ExtendThumbnail(videoID string, t *Thumbnail) error
+
+	// IsNotFound reports whether a video is cached as not found and the entry
+	// hasn't expired.
+	IsNotFound(videoID string) (bool, error)
+	// SetNotFound caches a video as not found until a given expiration.
+	SetNotFound(videoID string, expiration time.Time) error
+	// ClearNotFound removes all videos cached as not found and returns their
+	// number.
+	ClearNotFound() (int64, error)
+
+	// Close closes the cache.
+	Close() error
 }
 
-// Close closes the cache.
-func (c *Cache) Close() error {
-	return c.db.Close()
+// ThumbnailOpener is a Cache that streams thumbnail data instead of reading it
+// in full, e.g. a SQLiteCache with an S3BlobStore.
+type ThumbnailOpener interface {
+	// OpenThumbnail is GetThumbnail that streams the data of a thumbnail that
+	// isn't at hand: it returns the thumbnail without data and a reader of
+	// the data, which the caller must close. Otherwise the reader is nil and
+	// the thumbnail has data. The data is streamed until the context is done.
+	OpenThumbnail(ctx context.Context, videoID string) (*Thumbnail, io.ReadCloser, error)
 }
 
-// GetThumbnail returns a thumbnail from the cache.
-// If the thumbnail is not found in the cache, it returns ErrNotFound.
-func (c *Cache) GetThumbnail(videoID string) (*Thumbnail, error) {
-	query := `SELECT content_type, data, expires_at FROM cache WHERE video_id = ? AND expires_at > ?`
-	row := c.db.QueryRow(query, videoID, time.Now().Unix())
-
-	var contentType string
-	var data []byte
-	var expiration int64
-	err := row.Scan(&contentType, &data, &expiration)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, ErrNotFound
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	t := &Thumbnail{
-		ContentType: contentType,
-		Data:        data,
-		Expiration:  time.Unix(expiration, 0),
-	}
-	return t, nil
-}
-
-// SetThumbnail sets a thumbnail in the cache.
-func (c *Cache) SetThumbnail(videoID string, t *Thumbnail) error {
-	query := `INSERT OR REPLACE INTO cache (video_id, content_type, data, expires_at) VALUES (?, ?, ?, ?)`
-
-	if _, err := c.db.Exec(query, videoID, t.ContentType, t.Data, t.Expiration.Unix()); err != nil {
-		return err
-	}
-
-	return nil
+// AccessRecorder is a Cache that tracks the use of thumbnails for eviction,
+// e.g. a SQLiteCache, which is told of the reads served by a tier in front of
+// it.
+type AccessRecorder interface {
+	// RecordAccess records a read of a thumbnail at a given time. Accesses are
+	// recorded in batches in the background and may be lost.
+	RecordAccess(videoID string, at time.Time)
 }
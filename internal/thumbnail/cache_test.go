@@ -0,0 +1,555 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+// sqliteConfig is the SQLite config of the tests, the default config of the
+// server.
+var sqliteConfig = config.SQLiteConfig{JournalMode: "wal", Synchronous: "normal", BusyTimeout: 5 * time.Second, MaxReaders: 8}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	newThumbnail := func(size int) *thumbnail.Thumbnail {
+		return &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, size), Expiration: time.Now().Add(time.Hour)}
+	}
+	cache := thumbnail.NewMemoryCache(3000)
+
+	for _, key := range []string{"a", "b"} {
+		if err := cache.SetThumbnail(key, newThumbnail(1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Touch "a" so that "b" is the least recently used.
+	if _, err := cache.GetThumbnail("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetThumbnail("c", newThumbnail(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, err := cache.GetThumbnail(key)
+		if got := err == nil; got != want {
+			t.Errorf("GetThumbnail(%q) error = %v, want found %v", key, err, want)
+		}
+	}
+	if size := cache.Size(); size > 3000 {
+		t.Errorf("Size() = %d, want at most 3000", size)
+	}
+
+	// A thumbnail larger than the cache isn't stored and replaces nothing but
+	// its previous version.
+	if err := cache.SetThumbnail("a", newThumbnail(5000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetThumbnail("a"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail(%q) error = %v, want %v", "a", err, thumbnail.ErrNotFound)
+	}
+	if _, err := cache.GetThumbnail("c"); err != nil {
+		t.Errorf("GetThumbnail(%q) error = %v", "c", err)
+	}
+}
+
+func TestMemoryCacheNotFound(t *testing.T) {
+	cache := thumbnail.NewMemoryCache(1 << 20)
+
+	if err := cache.SetNotFound("a", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetNotFound("b", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false} {
+		if got, err := cache.IsNotFound(key); err != nil || got != want {
+			t.Errorf("IsNotFound(%q) = %v, %v, want %v", key, got, err, want)
+		}
+	}
+	if n, err := cache.ClearNotFound(); err != nil || n != 1 {
+		t.Errorf("ClearNotFound() = %d, %v, want 1", n, err)
+	}
+	if size := cache.Size(); size != 0 {
+		t.Errorf("Size() = %d, want 0", size)
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	cold, err := thumbnail.OpenSQLiteCache(":memory:", &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hot := thumbnail.NewMemoryCache(1 << 20)
+	cache := thumbnail.NewTieredCache(hot, cold)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	expiration := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	want := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: expiration, ETag: `"v1"`}
+
+	// Write-through.
+	if err := cache.SetThumbnail("a", want); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]thumbnail.Cache{"hot": hot, "cold": cold} {
+		if _, err := c.GetThumbnail("a"); err != nil {
+			t.Errorf("%s GetThumbnail() error = %v", name, err)
+		}
+	}
+
+	// Read-through.
+	if err := cold.SetThumbnail("b", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cache.GetThumbnail("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data, want.Data) || !got.Expiration.Equal(want.Expiration) || got.ETag != want.ETag {
+		t.Errorf("GetThumbnail() = %+v, want %+v", got, want)
+	}
+	if _, err := hot.GetThumbnail("b"); err != nil {
+		t.Errorf("hot GetThumbnail() error = %v, want the thumbnail copied to the hot tier", err)
+	}
+
+	// Both tiers are extended.
+	extended := &thumbnail.Thumbnail{Expiration: expiration.Add(time.Hour), ETag: `"v2"`}
+	if err := cache.ExtendThumbnail("a", extended); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]thumbnail.Cache{"hot": hot, "cold": cold} {
+		got, err := c.GetThumbnail("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ETag != `"v2"` || !got.Expiration.Equal(extended.Expiration) || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("%s GetThumbnail() = %+v, want the extended thumbnail", name, got)
+		}
+	}
+	if err := cache.ExtendThumbnail("c", extended); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("ExtendThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+
+	// A thumbnail evicted from the cold tier is still extended in the hot tier.
+	if err := hot.SetThumbnail("e", want); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.ExtendThumbnail("e", extended); err != nil {
+		t.Errorf("ExtendThumbnail() error = %v, want the hot tier extended", err)
+	}
+	if got, err := hot.GetThumbnail("e"); err != nil || got.ETag != `"v2"` {
+		t.Errorf("hot GetThumbnail() = %+v, %v, want the extended thumbnail", got, err)
+	}
+
+	// Not found videos are written through too.
+	if err := cache.SetNotFound("d", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]thumbnail.Cache{"hot": hot, "cold": cold, "tiered": cache} {
+		if notFound, err := c.IsNotFound("d"); err != nil || !notFound {
+			t.Errorf("%s IsNotFound() = %v, %v, want true", name, notFound, err)
+		}
+	}
+	if n, err := cache.ClearNotFound(); err != nil || n != 1 {
+		t.Errorf("ClearNotFound() = %d, %v, want 1", n, err)
+	}
+	if notFound, err := cache.IsNotFound("d"); err != nil || notFound {
+		t.Errorf("IsNotFound() = %v, %v, want false", notFound, err)
+	}
+}
+
+func TestTieredCacheAccesses(t *testing.T) {
+	cold, err := thumbnail.OpenSQLiteCache(":memory:", &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold.MaxEntries = 2
+	cache := thumbnail.NewTieredCache(thumbnail.NewMemoryCache(1<<20), cold)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	set := func(key string) {
+		t.Helper()
+		if err := cache.SetThumbnail(key, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte(key)}); err != nil {
+			t.Fatal(err)
+		}
+		// Access times have millisecond precision.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// "a" is read from the hot tier only, but the cold tier evicts "b" as
+	// the least recently used.
+	set("a")
+	set("b")
+	if _, err := cache.GetThumbnail("a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	set("c")
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, err := cold.GetThumbnail(key)
+		if got := err == nil; got != want {
+			t.Errorf("cold GetThumbnail(%q) error = %v, want found %v", key, err, want)
+		}
+	}
+}
+
+func TestTieredCacheLargeStream(t *testing.T) {
+	const dataSize = 8 << 20
+
+	cold, err := thumbnail.OpenSQLiteCache(":memory:", &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cold.Blobs, err = thumbnail.NewFileBlobStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	hot := thumbnail.NewMemoryCache(1 << 20)
+	cache := thumbnail.NewTieredCache(hot, cold)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	want := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, dataSize), Expiration: time.Now().Add(time.Hour)}
+	if err := cold.SetThumbnail("a", want); err != nil {
+		t.Fatal(err)
+	}
+
+	// A thumbnail larger than the hot tier is streamed without being kept.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, body, err := cache.OpenThumbnail(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, body)
+	if err := errors.Join(err, body.Close()); err != nil || n != dataSize {
+		t.Fatalf("read %d bytes, %v, want %d bytes", n, err, dataSize)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > dataSize/2 {
+		t.Errorf("allocated %d bytes, want the data not to be kept", allocated)
+	}
+	if _, err := hot.GetThumbnail("a"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("hot GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+}
+
+// brokenCache is a cache that fails every operation, e.g. an unavailable
+// Redis.
+type brokenCache struct{}
+
+var errBroken = errors.New("broken cache")
+
+func (brokenCache) GetThumbnail(string) (*thumbnail.Thumbnail, error)  { return nil, errBroken }
+func (brokenCache) SetThumbnail(string, *thumbnail.Thumbnail) error    { return errBroken }
+func (brokenCache) ExtendThumbnail(string, *thumbnail.Thumbnail) error { return errBroken }
+func (brokenCache) IsNotFound(string) (bool, error)                    { return false, errBroken }
+func (brokenCache) SetNotFound(string, time.Time) error                { return errBroken }
+func (brokenCache) ClearNotFound() (int64, error)                      { return 0, errBroken }
+func (brokenCache) Close() error                                       { return nil }
+
+func TestTieredCacheBrokenHotTier(t *testing.T) {
+	cold, err := thumbnail.OpenSQLiteCache(":memory:", &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := thumbnail.NewTieredCache(brokenCache{}, cold)
+	t.Cleanup(func() { _ = cache.Close() })
+
+	// Failures of the hot tier are misses.
+	want := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: time.Now().Add(time.Hour)}
+	if err := cache.SetThumbnail("a", want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.GetThumbnail("a"); err != nil || !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("GetThumbnail() = %v, %v, want the thumbnail of the cold tier", got, err)
+	}
+	if got, body, err := cache.OpenThumbnail(context.Background(), "a"); err != nil || body != nil || !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("OpenThumbnail() = %v, %v, %v, want the thumbnail of the cold tier", got, body, err)
+	}
+	if _, err := cache.GetThumbnail("b"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+
+	if err := cache.SetNotFound("c", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if notFound, err := cache.IsNotFound("c"); err != nil || !notFound {
+		t.Errorf("IsNotFound() = %v, %v, want true", notFound, err)
+	}
+}
+
+func TestSQLiteCacheEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		eviction string
+		want     map[string]bool
+	}{
+		// "a" is the most frequently used, "b" is the most recently used.
+		{name: "lru", eviction: thumbnail.EvictionLRU, want: map[string]bool{"a": false, "b": true, "c": true}},
+		{name: "lfu", eviction: thumbnail.EvictionLFU, want: map[string]bool{"a": true, "b": false, "c": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := thumbnail.OpenSQLiteCache(":memory:", &sqliteConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = cache.Close() })
+			cache.MaxEntries = 2
+			cache.Eviction = tt.eviction
+
+			set := func(key string) {
+				t.Helper()
+				if err := cache.SetThumbnail(key, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte(key)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			get := func(key string) {
+				t.Helper()
+				if _, err := cache.GetThumbnail(key); err != nil {
+					t.Fatal(err)
+				}
+				// Access times have millisecond precision.
+				time.Sleep(2 * time.Millisecond)
+			}
+
+			set("a")
+			get("a")
+			get("a")
+			set("b")
+			get("b")
+			set("c")
+
+			for key, want := range tt.want {
+				_, err := cache.GetThumbnail(key)
+				if got := err == nil; got != want {
+					t.Errorf("GetThumbnail(%q) error = %v, want found %v", key, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteCacheAccesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	cfg := sqliteConfig
+	cfg.BusyTimeout = 50 * time.Millisecond
+	cache, err := thumbnail.OpenSQLiteCache(path, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetThumbnail("a", &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	// Reads don't wait for the writer.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`DELETE FROM not_found`); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := cache.GetThumbnail("a"); err != nil {
+			t.Fatalf("GetThumbnail() error = %v, want the read not to wait for the write lock", err)
+		}
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The accesses are recorded once the cache is closed at the latest.
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var hits int
+	if err := db.QueryRow(`SELECT hits FROM cache WHERE video_id = 'a'`).Scan(&hits); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 3 {
+		t.Errorf("got %d hits, want 3", hits)
+	}
+}
+
+func TestSQLiteCacheConcurrentEviction(t *testing.T) {
+	const (
+		writers    = 8
+		writes     = 50
+		maxEntries = 20
+		dataSize   = 1000
+		maxSize    = 15 * dataSize
+	)
+
+	// In-memory databases are not shared between connections.
+	cache, err := thumbnail.OpenSQLiteCache(filepath.Join(t.TempDir(), "cache.db"), &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+	cache.MaxEntries = maxEntries
+	cache.MaxSize = maxSize
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers*writes)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				t := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, dataSize)}
+				if err := cache.SetThumbnail(fmt.Sprintf("%d-%d", w, i), t); err != nil {
+					errs <- err
+				}
+				// Reads record accesses, so they write too.
+				if _, err := cache.GetThumbnail(fmt.Sprintf("%d-%d", w, i)); err != nil && !errors.Is(err, thumbnail.ErrNotFound) {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	var entries, size int
+	for w := range writers {
+		for i := range writes {
+			got, err := cache.GetThumbnail(fmt.Sprintf("%d-%d", w, i))
+			if errors.Is(err, thumbnail.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries++
+			size += len(got.Data)
+		}
+	}
+	if entries == 0 || entries > maxEntries || size > maxSize {
+		t.Errorf("got %d entries of %d bytes, want 1-%d entries of at most %d bytes", entries, size, maxEntries, maxSize)
+	}
+}
+
+func BenchmarkSQLiteCache(b *testing.B) {
+	const (
+		thumbnails = 1000
+		dataSize   = 16 * 1024
+	)
+	configs := []struct {
+		name string
+		cfg  config.SQLiteConfig
+	}{
+		{name: "wal", cfg: sqliteConfig},
+		{name: "rollback", cfg: config.SQLiteConfig{JournalMode: "delete", Synchronous: "full", BusyTimeout: 5 * time.Second, MaxReaders: 8}},
+	}
+	workloads := []struct {
+		name string
+		// writeEvery is the share of writes: every writeEvery-th operation is
+		// a write, 0 means reads only.
+		writeEvery int64
+	}{
+		{name: "read", writeEvery: 0},
+		{name: "mixed", writeEvery: 10},
+		{name: "write", writeEvery: 1},
+	}
+
+	for _, c := range configs {
+		for _, w := range workloads {
+			b.Run(c.name+"/"+w.name, func(b *testing.B) {
+				cache, err := thumbnail.OpenSQLiteCache(filepath.Join(b.TempDir(), "cache.db"), &c.cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.Cleanup(func() { _ = cache.Close() })
+
+				t := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, dataSize), Expiration: time.Now().Add(time.Hour)}
+				for i := range thumbnails {
+					if err := cache.SetThumbnail(fmt.Sprint(i), t); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				var ops atomic.Int64
+				b.SetBytes(dataSize)
+				b.SetParallelism(4)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						op := ops.Add(1)
+						videoID := fmt.Sprint(op % thumbnails)
+						var err error
+						if w.writeEvery > 0 && op%w.writeEvery == 0 {
+							err = cache.SetThumbnail(videoID, t)
+						} else {
+							_, err = cache.GetThumbnail(videoID)
+						}
+						if err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkSQLiteCacheParallelReads(b *testing.B) {
+	const (
+		thumbnails = 1000
+		dataSize   = 16 * 1024
+	)
+	cache, err := thumbnail.OpenSQLiteCache(filepath.Join(b.TempDir(), "cache.db"), &sqliteConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = cache.Close() })
+
+	t := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, dataSize), Expiration: time.Now().Add(time.Hour)}
+	for i := range thumbnails {
+		if err := cache.SetThumbnail(fmt.Sprint(i), t); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// Reads neither wait for the writer nor for each other, so with enough
+	// CPUs the time per read falls as readers are added up to MaxReaders.
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("readers=%d", parallelism), func(b *testing.B) {
+			var ops atomic.Int64
+			b.SetBytes(dataSize)
+			b.SetParallelism(parallelism)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := cache.GetThumbnail(fmt.Sprint(ops.Add(1) % thumbnails)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
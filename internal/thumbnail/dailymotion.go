@@ -0,0 +1,95 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DailymotionURL is the default Dailymotion base URL.
+	DailymotionURL = "https://www.dailymotion.com"
+
+	// maxDailymotionVideoIDLength is the max length of a Dailymotion video ID.
+	maxDailymotionVideoIDLength = 20
+)
+
+// Dailymotion is the Dailymotion provider. Its thumbnail URLs are built
+// directly from video IDs, the thumbnail endpoint redirects to the image.
+type Dailymotion struct {
+	// BaseURL is the base URL of the thumbnail endpoint.
+	BaseURL string
+}
+
+// NewDailymotion creates a new Dailymotion provider that uses the default base
+// URL.
+func NewDailymotion() *Dailymotion {
+	return &Dailymotion{BaseURL: DailymotionURL}
+}
+
+// Name implements Provider.
+func (*Dailymotion) Name() string {
+	return "dailymotion"
+}
+
+// Match implements Provider.
+func (*Dailymotion) Match(u *url.URL) bool {
+	switch strings.ToLower(u.Hostname()) {
+	case "dailymotion.com", "www.dailymotion.com", "geo.dailymotion.com", "dai.ly":
+		return true
+	}
+	return false
+}
+
+// VideoID implements Provider.
+//
+// Regular (dailymotion.com/video/ID), embed (dailymotion.com/embed/video/ID),
+// player (geo.dailymotion.com/player.html?video=ID) and short (dai.ly/ID) URLs
+// are supported.
+func (*Dailymotion) VideoID(u *url.URL) (string, error) {
+	segments := pathSegments(u.Path)
+	host := strings.ToLower(u.Hostname())
+
+	var videoID string
+	switch {
+	case host == "dai.ly" && len(segments) == 1:
+		videoID = segments[0]
+	case host == "geo.dailymotion.com":
+		q, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidVideoURL, err)
+		}
+		videoID = q.Get("video")
+	case len(segments) == 2 && segments[0] == "video":
+		videoID = segments[1]
+	case len(segments) == 3 && segments[0] == "embed" && segments[1] == "video":
+		videoID = segments[2]
+	default:
+		return "", fmt.Errorf("%w: unknown Dailymotion URL path: %s", ErrInvalidVideoURL, u.Path)
+	}
+
+	// Old URLs have a slug after the ID, e.g. /video/x7tgad0_title.
+	videoID, _, _ = strings.Cut(videoID, "_")
+
+	if videoID == "" || len(videoID) > maxDailymotionVideoIDLength || !isAlphanumeric(videoID) {
+		return "", fmt.Errorf("%w: %q: Dailymotion video ID must be alphanumeric", ErrInvalidVideoID, videoID)
+	}
+	return videoID, nil
+}
+
+// ThumbnailURL implements Provider.
+func (d *Dailymotion) ThumbnailURL(_ context.Context, _ *Fetcher, videoID string) (string, error) {
+	return strings.TrimSuffix(d.BaseURL, "/") + "/thumbnail/video/" + videoID, nil
+}
+
+// isAlphanumeric reports whether s consists of ASCII letters and digits.
+func isAlphanumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
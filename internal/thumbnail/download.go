@@ -1,53 +1,155 @@
 package thumbnail
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 )
 
-// download downloads a thumbnail from a given URL.
-func download(url string) (*Thumbnail, error) {
-	resp, err := http.Get(url)
+var (
+	// fullFetches and revalidations expose the number of thumbnails
+	// downloaded in full and revalidated without transferring the body at
+	// /debug/vars.
+	fullFetches   = expvar.NewInt("thumbnail_full_fetches")
+	revalidations = expvar.NewInt("thumbnail_revalidations")
+	// placeholders exposes the number of downloaded placeholder images that
+	// were treated as not found at /debug/vars.
+	placeholders = expvar.NewInt("thumbnail_placeholders")
+)
+
+// download starts downloading a thumbnail from a given URL. The expiration of
+// the thumbnail is derived from the response headers by a given policy.
+// Thumbnails larger than maxSize bytes are rejected, zero maxSize means no
+// limit.
+//
+// The returned transfer is started once the image header is validated. The
+// caller must either copy the rest of the thumbnail or abort the transfer.
+//
+// If a stale copy of the thumbnail with validators is given, the request is
+// conditional. If upstream responds that the thumbnail is not modified, it
+// returns a complete transfer of the stale copy with the new expiration and
+// reports true.
+func download(ctx context.Context, fetcher *Fetcher, url string, stale *Thumbnail, freshness FreshnessPolicy, maxSize int64) (*transfer, bool, error) {
+	header := conditionalHeader(stale)
+	resp, err := fetcher.Get(ctx, url, header)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	defer func(resp *http.Response) {
+	closeBody := func() {
 		if err := resp.Body.Close(); err != nil {
 			slog.Error("failed to close response body", "error", err)
 		}
-	}(resp)
+	}
+
+	if resp.StatusCode == http.StatusNotModified && header != nil {
+		closeBody()
+		revalidations.Add(1)
+		t := *stale
+		t.Expiration = freshness.Expiration(resp.Header, time.Now())
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			t.ETag = etag
+		}
+		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+			t.LastModified = lastModified
+		}
+		return completeTransfer(&t), true, nil
+	}
 
 	if resp.StatusCode != http.StatusOK {
+		closeBody()
 		if resp.StatusCode == http.StatusNotFound {
-			return nil, ErrNotFound
+			return nil, false, ErrNotFound
 		}
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	return fromResponse(resp)
+	fullFetches.Add(1)
+	tr, err := fromResponse(url, resp, freshness, maxSize)
+	if err != nil {
+		closeBody()
+		return nil, false, err
+	}
+	return tr, false, nil
 }
 
-// fromResponse creates a Thumbnail from an HTTP response.
-// The response must be successful (status code 200).
-func fromResponse(resp *http.Response) (*Thumbnail, error) {
-	expiration, err := time.Parse(time.RFC1123, resp.Header.Get("Expires"))
+// conditionalHeader returns the headers of a request that revalidates a stale
+// thumbnail or nil if the thumbnail is nil or has no validators.
+func conditionalHeader(stale *Thumbnail) http.Header {
+	if stale == nil || stale.ETag == "" && stale.LastModified == "" {
+		return nil
+	}
+
+	header := make(http.Header)
+	if stale.ETag != "" {
+		header.Set("If-None-Match", stale.ETag)
+	}
+	if stale.LastModified != "" {
+		header.Set("If-Modified-Since", stale.LastModified)
+	}
+	return header
+}
+
+// getJSON sends a GET request to a given URL and decodes the JSON response body
+// into v. At most maxSize bytes of the body are read.
+// If the server responds with 404 or one of notFoundCodes, it returns
+// ErrNotFound.
+func getJSON(ctx context.Context, fetcher *Fetcher, url string, maxSize int64, v any, notFoundCodes ...int) error {
+	resp, err := fetcher.Get(ctx, url, http.Header{"Accept": {"application/json"}})
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer func(resp *http.Response) {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error("failed to close response body", "error", err)
+		}
+	}(resp)
 
-	data := bytes.NewBuffer(nil)
-	if _, err := io.Copy(data, resp.Body); err != nil {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound || slices.Contains(notFoundCodes, resp.StatusCode) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	t := &Thumbnail{
-		ContentType: resp.Header.Get("Content-Type"),
-		Data:        data.Bytes(),
-		Expiration:  expiration,
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSize)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+	return nil
+}
+
+// fromResponse starts a transfer of a thumbnail from an HTTP response.
+// The response must be successful (status code 200). The body must be a
+// supported image of at most maxSize bytes, see decodeImage. Only the image
+// header is read, the body is read further by the transfer.
+func fromResponse(url string, resp *http.Response, freshness FreshnessPolicy, maxSize int64) (*transfer, error) {
+	expiration := freshness.Expiration(resp.Header, time.Now())
+
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+
+	t := Thumbnail{
+		ContentType:  resp.Header.Get("Content-Type"),
+		Expiration:   expiration,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	head, err := decodeImage(&t, body)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(head)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxSize)
 	}
-	return t, nil
+	return newTransfer(t, url, head, resp.Body, maxSize), nil
 }
@@ -0,0 +1,366 @@
+package thumbnail
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+)
+
+// maxDiscardSize is the max size of a response body that is read before retrying
+// to reuse the connection.
+const maxDiscardSize = 64 << 10
+
+// maxBreakers is the number of circuit breakers of upstream hosts after which
+// the breakers in their initial state are dropped, so that the breakers of
+// hosts that are requested once don't pile up.
+const maxBreakers = 1024
+
+// Fetcher sends requests to upstream servers: thumbnail hosts, oEmbed
+// endpoints and playlist APIs. It applies the configured proxy, TLS roots,
+// connection pool limits and request headers.
+type Fetcher struct {
+	// Retry is the policy of retrying failed requests. The zero value
+	// disables retries.
+	Retry RetryPolicy
+	// Breaker is the policy of circuit breakers of upstream hosts. The zero
+	// value disables circuit breaking.
+	Breaker BreakerPolicy
+	// Limit is the policy of limiting the rate and the concurrency of
+	// requests. The zero value disables the limits. It must not be changed
+	// after the first request.
+	Limit LimitPolicy
+
+	client *http.Client
+	header http.Header
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	limiter  *limiter
+}
+
+// RetryPolicy is a policy of retrying failed requests with capped exponential
+// backoff and jitter. Network errors, 429 Too Many Requests and 5xx responses
+// other than 501 Not Implemented are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts including the first one.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, it doubles with
+	// every next retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries. Responses asking to retry
+	// later than MaxBackoff via Retry-After are not retried.
+	MaxBackoff time.Duration
+}
+
+// NewFetcher creates a new fetcher from the upstream configuration.
+func NewFetcher(cfg *config.UpstreamConfig) (*Fetcher, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.CABundle != "" {
+		rootCAs, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+
+	header := make(http.Header, len(cfg.Headers)+1)
+	for k, v := range cfg.Headers {
+		header.Set(k, v)
+	}
+	if cfg.UserAgent != "" {
+		header.Set("User-Agent", cfg.UserAgent)
+	}
+
+	f := NewFetcherWithClient(&http.Client{Transport: transport, Timeout: cfg.Timeout}, header)
+	f.Retry = RetryPolicy{
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		InitialBackoff: cfg.Retry.InitialBackoff,
+		MaxBackoff:     cfg.Retry.MaxBackoff,
+	}
+	f.Breaker = BreakerPolicy{
+		FailureThreshold: cfg.Breaker.FailureThreshold,
+		OpenTimeout:      cfg.Breaker.OpenTimeout,
+		HalfOpenRequests: cfg.Breaker.HalfOpenRequests,
+	}
+	f.Limit = LimitPolicy{
+		Rate:        cfg.Limit.Rate,
+		Burst:       cfg.Limit.Burst,
+		HostRate:    cfg.Limit.HostRate,
+		HostBurst:   cfg.Limit.HostBurst,
+		MaxInFlight: cfg.Limit.MaxInFlight,
+	}
+	return f, nil
+}
+
+// NewFetcherWithClient creates a new fetcher that sends requests with a custom
+// HTTP client and adds the given headers to every request.
+func NewFetcherWithClient(client *http.Client, header http.Header) *Fetcher {
+	return &Fetcher{client: client, header: header.Clone()}
+}
+
+// Get sends a GET request to a given URL with the configured headers and the
+// given extra headers, which may be nil. Failed requests are retried according
+// to the retry policy as long as the context deadline allows it, the last
+// response or error is returned.
+// If the circuit breaker of the host is open, it returns *CircuitOpenError.
+// The caller is responsible for closing the response body.
+func (f *Fetcher) Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	b := f.breaker(u.Host)
+	if b == nil {
+		return f.getWithRetries(ctx, u.Host, rawURL, header)
+	}
+	if retryAfter, ok := b.allow(); !ok {
+		return nil, &CircuitOpenError{Host: u.Host, RetryAfter: retryAfter}
+	}
+
+	resp, err := f.getWithRetries(ctx, u.Host, rawURL, header)
+	switch {
+	case ctx.Err() != nil || errors.Is(err, ErrRateLimited):
+		b.done(breakerIgnored)
+	case isRetryable(ctx, resp, err):
+		b.done(breakerFailure)
+	case err != nil:
+		// Errors that retries can't fix, e.g. an invalid certificate, say
+		// nothing about whether the host is available.
+		b.done(breakerIgnored)
+	default:
+		b.done(breakerSuccess)
+	}
+	return resp, err
+}
+
+// getWithRetries sends a GET request and retries it according to the retry
+// policy.
+func (f *Fetcher) getWithRetries(ctx context.Context, host, rawURL string, header http.Header) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := f.get(ctx, rawURL, header)
+		if attempt >= f.Retry.MaxAttempts || !isRetryable(ctx, resp, err) {
+			return resp, err
+		}
+
+		delay := f.Retry.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				if after > f.Retry.MaxBackoff {
+					return resp, nil
+				}
+				delay = max(delay, after)
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+
+		// Only the host is logged because URLs may contain API keys.
+		attrs := []any{"host", host, "attempt", attempt, "max_attempts", f.Retry.MaxAttempts, "delay", delay}
+		if resp != nil {
+			attrs = append(attrs, "status", resp.StatusCode)
+			discard(resp)
+		} else {
+			attrs = append(attrs, "error", err)
+		}
+		slog.Warn("retrying upstream request", attrs...)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// breaker returns the circuit breaker of a given host or nil if circuit
+// breaking is disabled.
+func (f *Fetcher) breaker(host string) *breaker {
+	if f.Breaker.FailureThreshold <= 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, ok := f.breakers[host]
+	if !ok {
+		if f.breakers == nil {
+			f.breakers = make(map[string]*breaker)
+		}
+		if len(f.breakers) >= maxBreakers {
+			f.dropIdleBreakers()
+		}
+		b = newBreaker(host, f.Breaker)
+		f.breakers[host] = b
+	}
+	return b
+}
+
+// dropIdleBreakers drops the circuit breakers that are closed and have no
+// failures, which are no different from new ones. Requests in flight through a
+// dropped breaker don't count towards the next breaker of the host. The caller
+// must hold f.mu.
+func (f *Fetcher) dropIdleBreakers() {
+	for host, b := range f.breakers {
+		if b.idle() {
+			delete(f.breakers, host)
+			breakerStates.Delete(host)
+		}
+	}
+}
+
+// limits returns the limiter of requests.
+func (f *Fetcher) limits() *limiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.limiter == nil {
+		f.limiter = newLimiter(f.Limit)
+	}
+	return f.limiter
+}
+
+// get sends a single GET request once the limits allow it.
+func (f *Fetcher) get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range f.header {
+		req.Header[k] = vs
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+
+	release, err := f.limits().acquire(ctx, req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// backoff returns the delay before a given retry, starting from 1. The delay is
+// picked randomly from the upper half of the capped exponential backoff.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MaxBackoff
+	if shift := retry - 1; shift < 32 {
+		if b := p.InitialBackoff << shift; b > 0 && b < d {
+			d = b
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d-d/2+1)
+}
+
+// isRetryable reports whether a failed request may be retried. Requests
+// canceled by the caller or rejected by the rate limits are never retried.
+func isRetryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrRateLimited) {
+		return false
+	}
+	if err != nil {
+		return isTransientError(err)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// isTransientError reports whether a request error may not repeat: a timeout,
+// a refused or reset connection or a connection closed mid-response. Errors
+// such as invalid certificates, malformed URLs and unknown hosts are not.
+func isTransientError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a
+// response, which may be either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// discard drains and closes a response body so that the connection can be
+// reused.
+func discard(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDiscardSize))
+	_ = resp.Body.Close()
+}
+
+// loadCABundle returns a pool with the system roots and the PEM certificates
+// from a given file.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstream CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in upstream CA bundle %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,332 @@
+package thumbnail_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestFetcherGet(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "youthumb-test" || r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("Accept") != "application/json" {
+			http.Error(w, "unexpected headers", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caBundle, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher, err := thumbnail.NewFetcher(&config.UpstreamConfig{
+		UserAgent: "youthumb-test",
+		Headers:   map[string]string{"X-Api-Key": "secret"},
+		CABundle:  caBundle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := fetcher.Get(context.Background(), srv.URL, http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Get() status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestNewFetcherErrors(t *testing.T) {
+	invalidBundle := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidBundle, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.UpstreamConfig
+	}{
+		{name: "invalid proxy URL", cfg: config.UpstreamConfig{ProxyURL: "http://[::1"}},
+		{name: "missing CA bundle", cfg: config.UpstreamConfig{CABundle: filepath.Join(t.TempDir(), "missing.pem")}},
+		{name: "invalid CA bundle", cfg: config.UpstreamConfig{CABundle: invalidBundle}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := thumbnail.NewFetcher(&tt.cfg); err == nil {
+				t.Error("NewFetcher() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestFetcherRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []int
+		retryAfter   string
+		wantStatus   int
+		wantAttempts int
+	}{
+		{name: "success", responses: []int{200}, wantStatus: 200, wantAttempts: 1},
+		{name: "transient 503", responses: []int{503, 502, 200}, wantStatus: 200, wantAttempts: 3},
+		{name: "429 with Retry-After", responses: []int{429, 200}, retryAfter: "0", wantStatus: 200, wantAttempts: 2},
+		{name: "Retry-After too long", responses: []int{503, 200}, retryAfter: "3600", wantStatus: 503, wantAttempts: 1},
+		{name: "attempts exhausted", responses: []int{500, 500, 500, 200}, wantStatus: 500, wantAttempts: 3},
+		{name: "not retryable 404", responses: []int{404, 200}, wantStatus: 404, wantAttempts: 1},
+		{name: "not retryable 501", responses: []int{501, 200}, wantStatus: 501, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				code := tt.responses[attempts]
+				attempts++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(code)
+			}))
+			t.Cleanup(srv.Close)
+
+			fetcher := thumbnail.NewFetcherWithClient(srv.Client(), nil)
+			fetcher.Retry = thumbnail.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+			resp, err := fetcher.Get(context.Background(), srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Get() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestFetcherRetryNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	fetcher := thumbnail.NewFetcherWithClient(http.DefaultClient, nil)
+	fetcher.Retry = thumbnail.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := fetcher.Get(ctx, url, nil); err == nil {
+		t.Fatal("Get() error = nil, want error")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Get() took %s, want it to give up before the deadline", elapsed)
+	}
+}
+
+func TestFetcherNotRetryable(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(tlsSrv.Close)
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		// The client doesn't trust the certificate of the test server.
+		{name: "invalid certificate", url: tlsSrv.URL},
+		{name: "unsupported scheme", url: "ftp://example.com/thumbnail.jpg"},
+		{name: "unknown host", url: "http://thumbnail.invalid/thumbnail.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := thumbnail.NewFetcherWithClient(&http.Client{}, nil)
+			fetcher.Retry = thumbnail.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Second, MaxBackoff: time.Second}
+
+			start := time.Now()
+			if _, err := fetcher.Get(context.Background(), tt.url, nil); err == nil {
+				t.Fatal("Get() error = nil, want error")
+			}
+			if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+				t.Errorf("Get() took %s, want it to fail without retries", elapsed)
+			}
+		})
+	}
+}
+
+func TestFetcherCircuitBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	fetcher := thumbnail.NewFetcherWithClient(srv.Client(), nil)
+	fetcher.Breaker = thumbnail.BreakerPolicy{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond, HalfOpenRequests: 1}
+
+	get := func() error {
+		resp, err := fetcher.Get(context.Background(), srv.URL, nil)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	// Consecutive failures open the breaker.
+	for range 2 {
+		if err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var circuitOpenErr *thumbnail.CircuitOpenError
+	if err := get(); !errors.As(err, &circuitOpenErr) || !errors.Is(err, thumbnail.ErrCircuitOpen) {
+		t.Fatalf("Get() error = %v, want CircuitOpenError", err)
+	}
+	if circuitOpenErr.RetryAfter <= 0 || circuitOpenErr.RetryAfter > 50*time.Millisecond {
+		t.Errorf("RetryAfter = %s, want (0, 50ms]", circuitOpenErr.RetryAfter)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+
+	// A failed trial opens the breaker again.
+	time.Sleep(50 * time.Millisecond)
+	if err := get(); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(); !errors.Is(err, thumbnail.ErrCircuitOpen) {
+		t.Fatalf("Get() error = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful trial closes the breaker.
+	healthy.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	for range 3 {
+		if err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 6 {
+		t.Errorf("got %d requests, want 6", requests)
+	}
+}
+
+func TestFetcherManyHosts(t *testing.T) {
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})}
+	fetcher := thumbnail.NewFetcherWithClient(client, nil)
+	fetcher.Breaker = thumbnail.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute}
+
+	// The breakers of healthy hosts are dropped rather than kept for every
+	// host ever requested.
+	for i := range 3000 {
+		resp, err := fetcher.Get(context.Background(), fmt.Sprintf("http://many-hosts-%d.test/", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	hosts := 0
+	expvar.Get("upstream_circuit_breaker_states").(*expvar.Map).Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, "many-hosts-") {
+			hosts++
+		}
+	})
+	if hosts > 1024 {
+		t.Errorf("got breakers of %d hosts, want at most 1024", hosts)
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper that calls itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestFetcherRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	fetcher := thumbnail.NewFetcherWithClient(srv.Client(), nil)
+	fetcher.Limit = thumbnail.LimitPolicy{Rate: 100, Burst: 100, HostRate: 1, HostBurst: 1}
+	fetcher.Retry = thumbnail.RetryPolicy{MaxAttempts: 3}
+
+	resp, err := fetcher.Get(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// The next token is available in a second, after the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fetcher.Get(ctx, srv.URL, nil); !errors.Is(err, thumbnail.ErrRateLimited) {
+		t.Errorf("Get() error = %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Get() took %s, want it to fail fast", elapsed)
+	}
+}
+
+func TestFetcherMaxInFlight(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	fetcher := thumbnail.NewFetcherWithClient(srv.Client(), nil)
+	fetcher.Limit = thumbnail.LimitPolicy{MaxInFlight: 1}
+
+	first, err := fetcher.Get(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request is in flight until its body is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if depth := expvar.Get("upstream_queue_depth").String(); depth != "1" {
+			t.Errorf("queue depth = %s, want 1", depth)
+		}
+	}()
+	if _, err := fetcher.Get(ctx, srv.URL, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want DeadlineExceeded", err)
+	}
+
+	_ = first.Body.Close()
+	second, err := fetcher.Get(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = second.Body.Close()
+}
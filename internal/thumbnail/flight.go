@@ -0,0 +1,84 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// flightGroup coalesces concurrent calls with the same key into one call whose
+// result is shared by all callers.
+type flightGroup[T any] struct {
+	mu      sync.Mutex
+	flights map[string]*flight[T]
+}
+
+// flight is an in-flight or completed call of a flightGroup.
+type flight[T any] struct {
+	done chan struct{}
+	v    T
+	err  error
+}
+
+// do calls fn once for all concurrent callers with the same key and returns its
+// result. The call runs with a context that is detached from the callers, so a
+// caller whose context is done returns its context error without aborting the
+// call for the rest. Neither is the call bounded by the deadline of the caller
+// that started it, since the rest may wait longer. The context of the call is
+// done once a given timeout elapses, zero means no timeout, so that a call is
+// never left running unbounded. It outlives the call, so that its result may
+// still be in use, e.g. a response body.
+func (g *flightGroup[T]) do(ctx context.Context, key string, timeout time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if !ok {
+		if g.flights == nil {
+			g.flights = make(map[string]*flight[T])
+		}
+		f = &flight[T]{done: make(chan struct{})}
+		g.flights[key] = f
+		callCtx, cancel := callContext(ctx, timeout)
+		go g.run(callCtx, cancel, key, f, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.v, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// callContext returns the context of a call started by a caller with a given
+// context, see do.
+func callContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// run calls fn and completes the flight. The context of a failed call is
+// canceled right away, otherwise once its timeout elapses.
+func (g *flightGroup[T]) run(ctx context.Context, cancel context.CancelFunc, key string, f *flight[T], fn func(ctx context.Context) (T, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero T
+			f.v, f.err = zero, fmt.Errorf("panic: %v", r)
+		}
+		if f.err != nil {
+			cancel()
+		}
+
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.v, f.err = fn(ctx)
+}
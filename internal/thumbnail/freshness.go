@@ -0,0 +1,133 @@
+package thumbnail
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FreshnessPolicy is a policy of deriving the expiration of cached thumbnails
+// from upstream response headers as a shared cache does per RFC 9111.
+type FreshnessPolicy struct {
+	// MinTTL and MaxTTL clamp the derived lifetime. Zero MaxTTL means no
+	// upper limit. Responses that must not be stored are never clamped.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// DefaultTTL is the lifetime of responses without freshness headers.
+	DefaultTTL time.Duration
+}
+
+// Expiration returns the time a response received at responseTime stops being
+// fresh. If the response must not be stored, the returned time is not after
+// responseTime.
+//
+// The freshness lifetime is taken from s-maxage, max-age or Expires minus Date,
+// in this order of precedence, and is reduced by the age of the response
+// derived from the Age and Date headers.
+func (p FreshnessPolicy) Expiration(header http.Header, responseTime time.Time) time.Time {
+	cc := parseCacheControl(header.Values("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return responseTime
+	}
+	if _, ok := cc["private"]; ok {
+		return responseTime
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = responseTime
+	}
+
+	lifetime, ok := freshnessLifetime(cc, header, date)
+	if !ok {
+		lifetime = p.DefaultTTL
+	} else {
+		lifetime -= currentAge(header, date, responseTime)
+	}
+
+	lifetime = max(lifetime, p.MinTTL, 0)
+	if p.MaxTTL > 0 {
+		lifetime = min(lifetime, p.MaxTTL)
+	}
+	return responseTime.Add(lifetime)
+}
+
+// freshnessLifetime returns the freshness lifetime of a response given its
+// parsed Cache-Control directives and its Date. It reports false if the
+// response has no explicit lifetime.
+func freshnessLifetime(cc map[string]string, header http.Header, date time.Time) (time.Duration, bool) {
+	// no-cache requires revalidation before every use.
+	if _, ok := cc["no-cache"]; ok {
+		return 0, true
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			if seconds, ok := parseDeltaSeconds(v); ok {
+				return seconds, true
+			}
+		}
+	}
+
+	if values := header.Values("Expires"); len(values) > 0 {
+		expires, err := http.ParseTime(values[0])
+		if err != nil {
+			// Invalid dates, e.g. "0", represent a time in the past.
+			return 0, true
+		}
+		return expires.Sub(date), true
+	}
+
+	return 0, false
+}
+
+// currentAge returns the age of a response received at responseTime, see
+// RFC 9111 Section 4.2.3.
+func currentAge(header http.Header, date, responseTime time.Time) time.Duration {
+	apparentAge := max(responseTime.Sub(date), 0)
+	age, ok := parseDeltaSeconds(header.Get("Age"))
+	if !ok {
+		age = 0
+	}
+	return max(apparentAge, age)
+}
+
+// parseCacheControl parses Cache-Control header values into a map of
+// lowercase directive names to their unquoted values.
+func parseCacheControl(values []string) map[string]string {
+	cc := make(map[string]string)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			// The first occurrence of a directive wins.
+			if _, ok := cc[name]; !ok {
+				cc[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// parseDeltaSeconds parses a non-negative number of seconds. Values that
+// overflow are capped, see RFC 9111 Section 1.2.2.
+func parseDeltaSeconds(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+
+	const maxSeconds = 1<<31 - 1
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seconds > maxSeconds {
+		seconds = maxSeconds
+	}
+	return time.Duration(seconds) * time.Second, true
+}
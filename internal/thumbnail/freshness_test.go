@@ -0,0 +1,66 @@
+package thumbnail_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestFreshnessPolicyExpiration(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	httpTime := func(d time.Duration) string {
+		return now.Add(d).Format(http.TimeFormat)
+	}
+	policy := thumbnail.FreshnessPolicy{DefaultTTL: time.Hour}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		policy *thumbnail.FreshnessPolicy
+		want   time.Duration
+	}{
+		{name: "no headers", header: http.Header{}, want: time.Hour},
+		{name: "max-age", header: http.Header{"Cache-Control": {"public, max-age=7200"}}, want: 2 * time.Hour},
+		{name: "s-maxage over max-age", header: http.Header{"Cache-Control": {"max-age=7200, s-maxage=600"}}, want: 10 * time.Minute},
+		{name: "quoted max-age", header: http.Header{"Cache-Control": {`max-age="60"`}}, want: time.Minute},
+		{name: "uppercase directive", header: http.Header{"Cache-Control": {"MAX-AGE=60"}}, want: time.Minute},
+		{name: "several headers", header: http.Header{"Cache-Control": {"public", "max-age=60"}}, want: time.Minute},
+		{name: "invalid max-age", header: http.Header{"Cache-Control": {"max-age=1h"}}, want: time.Hour},
+		{name: "negative max-age", header: http.Header{"Cache-Control": {"max-age=-1"}}, want: time.Hour},
+		{name: "overflowing max-age", header: http.Header{"Cache-Control": {"max-age=99999999999999999999"}}, want: (1<<31 - 1) * time.Second},
+		{name: "max-age over Expires", header: http.Header{"Cache-Control": {"max-age=60"}, "Expires": {httpTime(time.Hour)}}, want: time.Minute},
+		{name: "max-age with Age", header: http.Header{"Cache-Control": {"max-age=7200"}, "Age": {"3600"}}, want: time.Hour},
+		{name: "max-age with old Date", header: http.Header{"Cache-Control": {"max-age=7200"}, "Date": {httpTime(-30 * time.Minute)}}, want: 90 * time.Minute},
+		{name: "Age over Date", header: http.Header{"Cache-Control": {"max-age=7200"}, "Date": {httpTime(-time.Minute)}, "Age": {"600"}}, want: 110 * time.Minute},
+		{name: "Date in the future", header: http.Header{"Cache-Control": {"max-age=60"}, "Date": {httpTime(time.Hour)}}, want: time.Minute},
+		{name: "Age above max-age", header: http.Header{"Cache-Control": {"max-age=60"}, "Age": {"120"}}, want: 0},
+		{name: "Expires", header: http.Header{"Expires": {httpTime(time.Hour)}}, want: time.Hour},
+		{name: "Expires relative to Date", header: http.Header{"Expires": {httpTime(time.Hour)}, "Date": {httpTime(-time.Hour)}}, want: time.Hour},
+		{name: "Expires in RFC 850 format", header: http.Header{"Expires": {now.Add(time.Hour).Format(time.RFC850)}}, want: time.Hour},
+		{name: "Expires in the past", header: http.Header{"Expires": {httpTime(-time.Hour)}}, want: 0},
+		{name: "invalid Expires", header: http.Header{"Expires": {"0"}}, want: 0},
+		{name: "no-store", header: http.Header{"Cache-Control": {"no-store, max-age=60"}}, want: 0},
+		{name: "private", header: http.Header{"Cache-Control": {"private, max-age=60"}}, want: 0},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"no-cache, max-age=60"}}, want: 0},
+		{name: "min TTL", header: http.Header{"Cache-Control": {"max-age=60"}}, policy: &thumbnail.FreshnessPolicy{MinTTL: time.Hour}, want: time.Hour},
+		{name: "min TTL for expired", header: http.Header{"Expires": {"0"}}, policy: &thumbnail.FreshnessPolicy{MinTTL: time.Minute}, want: time.Minute},
+		{name: "min TTL for no-store", header: http.Header{"Cache-Control": {"no-store"}}, policy: &thumbnail.FreshnessPolicy{MinTTL: time.Minute}, want: 0},
+		{name: "max TTL", header: http.Header{"Cache-Control": {"max-age=31536000"}}, policy: &thumbnail.FreshnessPolicy{MaxTTL: 24 * time.Hour}, want: 24 * time.Hour},
+		{name: "max TTL for default", header: http.Header{}, policy: &thumbnail.FreshnessPolicy{DefaultTTL: time.Hour, MaxTTL: time.Minute}, want: time.Minute},
+		{name: "zero default TTL", header: http.Header{}, policy: &thumbnail.FreshnessPolicy{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := policy
+			if tt.policy != nil {
+				p = *tt.policy
+			}
+			if got := p.Expiration(tt.header, now).Sub(now); got != tt.want {
+				t.Errorf("Expiration() = now + %s, want now + %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	// ErrInvalidImage is returned when upstream responds with something other
+	// than a supported image, e.g. an HTML error page.
+	ErrInvalidImage = errors.New("invalid thumbnail image")
+	// ErrTooLarge is returned when a thumbnail exceeds the max size.
+	ErrTooLarge = errors.New("thumbnail is too large")
+)
+
+// sniffSize is the number of bytes http.DetectContentType considers.
+const sniffSize = 512
+
+// decodeImage reads the header of an image from r, checks that it's a JPEG,
+// PNG, GIF or WebP image and sets the content type and the dimensions of a
+// thumbnail. A content type that doesn't match the data is corrected.
+//
+// It returns the bytes read from r, which are at least the header and the
+// first bytes needed for content sniffing if the image is that large.
+func decodeImage(t *Thumbnail, r io.Reader) ([]byte, error) {
+	var head bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err == nil && head.Len() < sniffSize {
+		_, err = io.CopyN(&head, r, int64(sniffSize-head.Len()))
+		if err == io.EOF {
+			err = nil
+		}
+	}
+
+	if sniffed := http.DetectContentType(head.Bytes()); !strings.HasPrefix(sniffed, "image/") {
+		return nil, fmt.Errorf("%w: content looks like %s", ErrInvalidImage, sniffed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+
+	contentType := "image/" + format
+	if mediaType, _, err := mime.ParseMediaType(t.ContentType); err != nil || mediaType != contentType {
+		slog.Warn("correcting thumbnail content type", "content_type", t.ContentType, "detected", contentType)
+		t.ContentType = contentType
+	}
+	t.Width, t.Height = cfg.Width, cfg.Height
+	return head.Bytes(), nil
+}
@@ -0,0 +1,121 @@
+package thumbnail
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Sweeper is a cache whose expired entries are deleted by a Janitor.
+type Sweeper interface {
+	// DeleteExpired deletes at most limit thumbnails and at most limit videos
+	// cached as not found that expired before a given time. It returns the
+	// number of deleted entries and the size of the deleted thumbnail data in
+	// bytes.
+	DeleteExpired(before time.Time, limit int) (int64, int64, error)
+}
+
+// vacuumer is a Sweeper that returns the space freed by deleted entries to
+// the file system on demand, e.g. SQLiteCache.
+type vacuumer interface {
+	IncrementalVacuum(pages int) (int64, error)
+}
+
+// blobCollector is a Sweeper that stores thumbnail data in a blob store, e.g.
+// SQLiteCache or PostgresCache.
+type blobCollector interface {
+	CollectBlobs(ctx context.Context, before time.Time) (int64, int64, error)
+}
+
+// Janitor periodically deletes expired entries and orphaned blobs from a cache
+// and returns the freed space to the file system.
+type Janitor struct {
+	// Interval is the time between sweeps.
+	Interval time.Duration
+	// BatchSize is the max number of entries deleted by a single statement,
+	// so that a sweep doesn't block the cache for long.
+	BatchSize int
+	// Retention is the time expired thumbnails are kept after their
+	// expiration, e.g. to be served stale.
+	Retention time.Duration
+	// Vacuum enables incremental vacuum after every sweep that deletes
+	// entries if the cache supports it.
+	Vacuum bool
+	// BlobGracePeriod is the time an unreferenced blob is kept after its last
+	// write, so that the blobs of thumbnails being written aren't deleted.
+	BlobGracePeriod time.Duration
+
+	cache Sweeper
+}
+
+// NewJanitor creates a new janitor of a given cache.
+func NewJanitor(cache Sweeper) *Janitor {
+	return &Janitor{cache: cache}
+}
+
+// Run sweeps the cache right away and then every interval until the context is
+// done.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.Sweep(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to sweep cache", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep deletes the entries that expired more than the retention ago in
+// batches, vacuums the database if enabled, deletes the orphaned blobs and
+// logs what was reclaimed. It stops between batches once the context is done.
+func (j *Janitor) Sweep(ctx context.Context) error {
+	start := time.Now()
+	before := start.Add(-j.Retention)
+
+	var rows, size, vacuumed, blobs, blobSize int64
+	defer func() {
+		slog.Info("swept cache", "rows", rows, "bytes", size, "vacuumed_bytes", vacuumed, "blobs", blobs, "blob_bytes", blobSize, "duration", time.Since(start))
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, batchSize, err := j.cache.DeleteExpired(before, j.BatchSize)
+		rows += n
+		size += batchSize
+		if err != nil {
+			return err
+		}
+		// Both tables are drained once a batch deletes fewer entries than
+		// a single table may.
+		if n < int64(j.BatchSize) {
+			break
+		}
+	}
+
+	if v, ok := j.cache.(vacuumer); ok && j.Vacuum && rows > 0 {
+		var err error
+		if vacuumed, err = v.IncrementalVacuum(0); err != nil {
+			return err
+		}
+	}
+
+	// Evicted and replaced thumbnails orphan blobs too, so blobs are collected
+	// regardless of the deleted entries.
+	if c, ok := j.cache.(blobCollector); ok {
+		var err error
+		if blobs, blobSize, err = c.CollectBlobs(ctx, start.Add(-j.BlobGracePeriod)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
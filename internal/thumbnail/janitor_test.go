@@ -0,0 +1,148 @@
+package thumbnail_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestJanitorSweep(t *testing.T) {
+	cache, err := thumbnail.OpenSQLiteCache(filepath.Join(t.TempDir(), "cache.db"), &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	now := time.Now()
+	expirations := map[string]time.Time{
+		"fresh":            now.Add(time.Hour),
+		"within retention": now.Add(-time.Minute),
+	}
+	for i := range 5 {
+		expirations[fmt.Sprintf("expired %d", i)] = now.Add(-2 * time.Hour)
+	}
+	for videoID, expiration := range expirations {
+		th := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, 64*1024), Expiration: expiration}
+		if err := cache.SetThumbnail(videoID, th); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.SetNotFound("not found", now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := thumbnail.NewJanitor(cache)
+	janitor.BatchSize = 2
+	janitor.Retention = time.Hour
+	janitor.Vacuum = true
+	if err := janitor.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for videoID := range expirations {
+		_, err := cache.GetThumbnail(videoID)
+		want := videoID == "fresh" || videoID == "within retention"
+		if got := err == nil; got != want {
+			t.Errorf("GetThumbnail(%q) error = %v, want found %v", videoID, err, want)
+		}
+	}
+	if n, err := cache.ClearNotFound(); err != nil || n != 0 {
+		t.Errorf("ClearNotFound() = %d, %v, want the expired video swept", n, err)
+	}
+	if n, err := cache.IncrementalVacuum(0); err != nil || n != 0 {
+		t.Errorf("IncrementalVacuum() = %d, %v, want the free pages vacuumed by the sweep", n, err)
+	}
+}
+
+func TestSQLiteCacheEnableIncrementalVacuum(t *testing.T) {
+	// A database created before incremental auto-vacuum was enabled.
+	dsn := filepath.Join(t.TempDir(), "cache.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE cache (video_id TEXT PRIMARY KEY, content_type TEXT NOT NULL, data BLOB NOT NULL, expires_at INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := thumbnail.OpenSQLiteCache(dsn, &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	// sweep deletes expired thumbnails and returns the bytes of the free pages
+	// that are returned to the file system.
+	sweep := func() int64 {
+		t.Helper()
+		for i := range 5 {
+			th := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, 64*1024), Expiration: time.Now().Add(-2 * time.Hour)}
+			if err := cache.SetThumbnail(fmt.Sprintf("expired %d", i), th); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, _, err := cache.DeleteExpired(time.Now(), 10); err != nil {
+			t.Fatal(err)
+		}
+		n, err := cache.IncrementalVacuum(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := sweep(); n != 0 {
+		t.Errorf("IncrementalVacuum() = %d, want nothing vacuumed before converting", n)
+	}
+	if converted, err := cache.EnableIncrementalVacuum(); err != nil || !converted {
+		t.Fatalf("EnableIncrementalVacuum() = %v, %v, want converted", converted, err)
+	}
+	if converted, err := cache.EnableIncrementalVacuum(); err != nil || converted {
+		t.Errorf("EnableIncrementalVacuum() = %v, %v, want already converted", converted, err)
+	}
+	if n := sweep(); n == 0 {
+		t.Errorf("IncrementalVacuum() = 0, want the free pages vacuumed after converting")
+	}
+}
+
+func TestJanitorRun(t *testing.T) {
+	cache, err := thumbnail.OpenSQLiteCache(":memory:", &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	janitor := thumbnail.NewJanitor(cache)
+	janitor.Interval = time.Millisecond
+	janitor.BatchSize = 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		janitor.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() didn't stop after the context was canceled")
+	}
+	if err := janitor.Sweep(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sweep() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,182 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxHostLimiters is the number of rate limiters of upstream hosts after which
+// the limiters with full token buckets are dropped, so that the limiters of
+// hosts that are requested once don't pile up.
+const maxHostLimiters = 1024
+
+var (
+	// ErrRateLimited is returned when an upstream request can't be sent before
+	// the caller's deadline because of the rate limits.
+	ErrRateLimited = errors.New("upstream rate limit exceeded")
+)
+
+var (
+	// queueDepth exposes the number of upstream requests waiting for the rate
+	// limits or a free in-flight slot at /debug/vars.
+	queueDepth = expvar.NewInt("upstream_queue_depth")
+	// queueWaits and queueWaitSeconds expose the number of upstream requests
+	// that left the queue and the total time they spent in it at /debug/vars.
+	queueWaits       = expvar.NewInt("upstream_queue_waits")
+	queueWaitSeconds = expvar.NewFloat("upstream_queue_wait_seconds")
+	// inFlight exposes the number of in-flight upstream requests at
+	// /debug/vars.
+	inFlight = expvar.NewInt("upstream_in_flight")
+)
+
+// LimitPolicy is a policy of limiting the rate and the concurrency of upstream
+// requests. Every attempt of a retried request counts as a separate request.
+type LimitPolicy struct {
+	// Rate is the max number of requests per second to all hosts, zero means
+	// no limit. Burst is the size of the token bucket.
+	Rate  float64
+	Burst int
+	// HostRate is the max number of requests per second to a single host,
+	// zero means no limit. HostBurst is the size of the token bucket.
+	HostRate  float64
+	HostBurst int
+	// MaxInFlight is the max number of concurrent requests to all hosts, zero
+	// means no limit. A request is in flight until its response body is
+	// closed.
+	MaxInFlight int
+}
+
+// limiter limits upstream requests according to a policy.
+type limiter struct {
+	policy LimitPolicy
+	// global is nil if the global rate is not limited.
+	global *rate.Limiter
+	// slots is nil if the number of in-flight requests is not limited.
+	slots chan struct{}
+
+	mu    sync.Mutex
+	hosts map[string]*rate.Limiter
+}
+
+// newLimiter creates a new limiter that follows a given policy.
+func newLimiter(policy LimitPolicy) *limiter {
+	l := &limiter{policy: policy, hosts: make(map[string]*rate.Limiter)}
+	if policy.Rate > 0 {
+		l.global = rate.NewLimiter(rate.Limit(policy.Rate), max(policy.Burst, 1))
+	}
+	if policy.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, policy.MaxInFlight)
+	}
+	return l
+}
+
+// rateLimitedKey is the context key of the flag of requests waiting for the
+// rate limits, see withRateLimitedFlag.
+type rateLimitedKey struct{}
+
+// withRateLimitedFlag returns a context whose requests set the returned flag
+// while they wait for the rate limits, so that callers waiting for them know
+// why they take long.
+func withRateLimitedFlag(ctx context.Context) (context.Context, *atomic.Bool) {
+	flag := new(atomic.Bool)
+	return context.WithValue(ctx, rateLimitedKey{}, flag), flag
+}
+
+// acquire waits until a request to a given host may be sent. If the wait would
+// exceed the context deadline, it returns an error wrapping ErrRateLimited.
+// The returned function must be called once the request is complete.
+func (l *limiter) acquire(ctx context.Context, host string) (func(), error) {
+	start := time.Now()
+	queueDepth.Add(1)
+	defer func() {
+		queueDepth.Add(-1)
+		queueWaits.Add(1)
+		queueWaitSeconds.Add(time.Since(start).Seconds())
+	}()
+
+	if flag, ok := ctx.Value(rateLimitedKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+		defer flag.Store(false)
+	}
+	for _, rl := range []*rate.Limiter{l.global, l.host(host)} {
+		if rl == nil {
+			continue
+		}
+		if err := rl.Wait(ctx); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, fmt.Errorf("%w: %s: %w", ErrRateLimited, host, err)
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	inFlight.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inFlight.Add(-1)
+			if l.slots != nil {
+				<-l.slots
+			}
+		})
+	}, nil
+}
+
+// host returns the rate limiter of a given host or nil if the rate of a
+// single host is not limited.
+func (l *limiter) host(host string) *rate.Limiter {
+	if l.policy.HostRate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rl, ok := l.hosts[host]
+	if !ok {
+		if len(l.hosts) >= maxHostLimiters {
+			l.dropFullHosts()
+		}
+		rl = rate.NewLimiter(rate.Limit(l.policy.HostRate), max(l.policy.HostBurst, 1))
+		l.hosts[host] = rl
+	}
+	return rl
+}
+
+// dropFullHosts drops the rate limiters of hosts whose token buckets are
+// full, which are no different from new ones. The caller must hold l.mu.
+func (l *limiter) dropFullHosts() {
+	for host, rl := range l.hosts {
+		if rl.Tokens() >= float64(rl.Burst()) {
+			delete(l.hosts, host)
+		}
+	}
+}
+
+// releaseOnClose is a response body that completes an in-flight request when
+// it is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
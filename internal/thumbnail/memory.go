@@ -0,0 +1,199 @@
+package thumbnail
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+var (
+	// memoryHits, memoryMisses and memoryEvictions expose the number of
+	// lookups served by the in-memory caches, the number of lookups they
+	// missed and the number of entries they evicted at /debug/vars.
+	memoryHits      = expvar.NewInt("cache_memory_hits")
+	memoryMisses    = expvar.NewInt("cache_memory_misses")
+	memoryEvictions = expvar.NewInt("cache_memory_evictions")
+)
+
+// memoryEntryOverhead is the approximate size of an entry in bytes besides its
+// strings and data.
+const memoryEntryOverhead = 128
+
+// MemoryCache is a cache for thumbnail images stored in memory. It's bounded by
+// the total size of its entries and evicts the least recently used entries
+// when it's full.
+type MemoryCache struct {
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[memoryKey]*list.Element
+}
+
+// memoryKey is a key of a MemoryCache entry. Thumbnails and videos that are
+// not found are separate entries.
+type memoryKey struct {
+	videoID  string
+	notFound bool
+}
+
+// memoryEntry is a MemoryCache entry, it's either a thumbnail or a video that
+// is not found.
+type memoryEntry struct {
+	key memoryKey
+	// t must not be modified, it's replaced instead.
+	t          *Thumbnail
+	expiration time.Time
+	size       int64
+}
+
+// NewMemoryCache creates a new in-memory cache of at most maxSize bytes.
+// Thumbnails larger than the cache aren't stored.
+func NewMemoryCache(maxSize int64) *MemoryCache {
+	return &MemoryCache{maxSize: maxSize, lru: list.New(), entries: make(map[memoryKey]*list.Element)}
+}
+
+// maxEntrySize implements entryLimiter.
+func (c *MemoryCache) maxEntrySize() int64 {
+	return c.maxSize
+}
+
+// Close implements Cache.
+func (c *MemoryCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = 0
+	c.lru.Init()
+	clear(c.entries)
+	return nil
+}
+
+// GetThumbnail implements Cache. The returned thumbnail must not be modified.
+func (c *MemoryCache) GetThumbnail(videoID string) (*Thumbnail, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[memoryKey{videoID: videoID}]
+	if !ok {
+		memoryMisses.Add(1)
+		return nil, ErrNotFound
+	}
+	memoryHits.Add(1)
+	c.lru.MoveToFront(e)
+	return e.Value.(*memoryEntry).t, nil
+}
+
+// SetThumbnail implements Cache. The thumbnail must not be modified after it's
+// set.
+func (c *MemoryCache) SetThumbnail(videoID string, t *Thumbnail) error {
+	size := int64(memoryEntryOverhead + len(videoID) + len(t.ContentType) + len(t.Data) + len(t.ETag) + len(t.LastModified))
+	c.set(&memoryEntry{key: memoryKey{videoID: videoID}, t: t, size: size})
+	return nil
+}
+
+// ExtendThumbnail implements Cache.
+func (c *MemoryCache) ExtendThumbnail(videoID string, t *Thumbnail) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[memoryKey{videoID: videoID}]
+	if !ok {
+		return ErrNotFound
+	}
+	entry := e.Value.(*memoryEntry)
+	extended := *entry.t
+	extended.Expiration, extended.ETag, extended.LastModified = t.Expiration, t.ETag, t.LastModified
+	delta := int64(len(extended.ETag) + len(extended.LastModified) - len(entry.t.ETag) - len(entry.t.LastModified))
+	entry.t = &extended
+	entry.size += delta
+	c.size += delta
+	c.lru.MoveToFront(e)
+	c.evict()
+	return nil
+}
+
+// IsNotFound implements Cache.
+func (c *MemoryCache) IsNotFound(videoID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[memoryKey{videoID: videoID, notFound: true}]
+	if !ok {
+		return false, nil
+	}
+	entry := e.Value.(*memoryEntry)
+	if !entry.expiration.After(time.Now()) {
+		c.remove(e)
+		return false, nil
+	}
+	c.lru.MoveToFront(e)
+	return true, nil
+}
+
+// SetNotFound implements Cache.
+func (c *MemoryCache) SetNotFound(videoID string, expiration time.Time) error {
+	key := memoryKey{videoID: videoID, notFound: true}
+	c.set(&memoryEntry{key: key, expiration: expiration, size: int64(memoryEntryOverhead + len(videoID))})
+	return nil
+}
+
+// ClearNotFound implements Cache.
+func (c *MemoryCache) ClearNotFound() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int64
+	for key, e := range c.entries {
+		if key.notFound {
+			c.remove(e)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Size returns the total size of the entries in bytes.
+func (c *MemoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// set adds or replaces an entry and evicts the least recently used entries
+// until the cache fits its max size. Entries larger than the cache only remove
+// the entries they replace.
+func (c *MemoryCache) set(entry *memoryEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[entry.key]; ok {
+		c.remove(e)
+	}
+	if entry.size > c.maxSize {
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits its max
+// size. It must be called with mu held.
+func (c *MemoryCache) evict() {
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+		memoryEvictions.Add(1)
+	}
+}
+
+// remove removes an entry. It must be called with mu held.
+func (c *MemoryCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*memoryEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
@@ -0,0 +1,232 @@
+package thumbnail
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrSchemaTooNew is returned when a database was migrated by a newer version
+// of the server than the running one.
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+// Migration is a versioned change of a cache database schema. The version of a
+// database is the version of the last migration applied to it. SQLite
+// databases track it in PRAGMA user_version.
+type Migration struct {
+	Version     int
+	Description string
+
+	statements []string
+}
+
+// migrations are the migrations of the SQLite cache schema in order. A
+// migration must never change once released, schema changes are added as new
+// migrations.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create cache table",
+		statements: []string{`
+			CREATE TABLE cache (
+				video_id TEXT PRIMARY KEY,
+				content_type TEXT NOT NULL,
+				data BLOB NOT NULL,
+				expires_at INTEGER NOT NULL
+			)
+		`},
+	},
+	{
+		Version:     2,
+		Description: "add thumbnail dimensions",
+		statements: []string{
+			`ALTER TABLE cache ADD COLUMN width INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE cache ADD COLUMN height INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "add thumbnail validators",
+		statements: []string{
+			`ALTER TABLE cache ADD COLUMN etag TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE cache ADD COLUMN last_modified TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "create not_found table",
+		statements: []string{`
+			CREATE TABLE not_found (
+				video_id TEXT PRIMARY KEY,
+				expires_at INTEGER NOT NULL
+			)
+		`},
+	},
+	{
+		Version:     5,
+		Description: "track thumbnail usage and cache totals",
+		statements: []string{
+			`ALTER TABLE cache ADD COLUMN accessed_at INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE cache ADD COLUMN hits INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE cache ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE cache SET size = length(data)`,
+			`CREATE INDEX cache_lru ON cache (accessed_at)`,
+			`CREATE INDEX cache_lfu ON cache (hits, accessed_at)`,
+			// cache_stats keeps the totals of the cache table up to date, so
+			// that the limits are checked without scanning the table.
+			`
+			CREATE TABLE cache_stats (
+				id INTEGER PRIMARY KEY CHECK (id = 0),
+				entries INTEGER NOT NULL,
+				bytes INTEGER NOT NULL
+			)
+			`,
+			`INSERT INTO cache_stats (id, entries, bytes) SELECT 0, COUNT(*), COALESCE(SUM(size), 0) FROM cache`,
+			`
+			CREATE TRIGGER cache_stats_insert AFTER INSERT ON cache BEGIN
+				UPDATE cache_stats SET entries = entries + 1, bytes = bytes + NEW.size;
+			END
+			`,
+			`
+			CREATE TRIGGER cache_stats_update AFTER UPDATE OF size ON cache BEGIN
+				UPDATE cache_stats SET bytes = bytes - OLD.size + NEW.size;
+			END
+			`,
+			`
+			CREATE TRIGGER cache_stats_delete AFTER DELETE ON cache BEGIN
+				UPDATE cache_stats SET entries = entries - 1, bytes = bytes - OLD.size;
+			END
+			`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "index expiration times",
+		statements: []string{
+			`CREATE INDEX cache_expires_at ON cache (expires_at)`,
+			`CREATE INDEX not_found_expires_at ON not_found (expires_at)`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "reference thumbnail data in blob stores",
+		statements: []string{
+			`ALTER TABLE cache ADD COLUMN blob_hash TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX cache_blob_hash ON cache (blob_hash) WHERE blob_hash != ''`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "namespace YouTube video IDs",
+		statements: []string{
+			// Thumbnails were cached by bare YouTube video IDs before keys
+			// were namespaced by the provider. A legacy row is dropped if the
+			// namespaced one exists, since the latter is newer.
+			`DELETE FROM cache WHERE instr(video_id, ':') = 0 AND 'youtube:' || video_id IN (SELECT video_id FROM cache)`,
+			`UPDATE cache SET video_id = 'youtube:' || video_id WHERE instr(video_id, ':') = 0`,
+			`DELETE FROM not_found WHERE instr(video_id, ':') = 0 AND 'youtube:' || video_id IN (SELECT video_id FROM not_found)`,
+			`UPDATE not_found SET video_id = 'youtube:' || video_id WHERE instr(video_id, ':') = 0`,
+		},
+	},
+}
+
+// SchemaVersion is the version of the SQLite cache schema the running server
+// migrates databases to.
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// migrate applies the pending migrations to a database, each in its own
+// transaction. If dryRun is true, it only returns the pending migrations.
+//
+// Databases created before the migrations were introduced have no version,
+// their version is derived from their schema.
+func migrate(db *sql.DB, dryRun bool) ([]Migration, error) {
+	version, legacy, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%w: version %d, supported %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+
+	pending := migrations[version:]
+	if dryRun {
+		return pending, nil
+	}
+	if legacy {
+		if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+			return nil, err
+		}
+		slog.Info("adopted unversioned database", "version", version)
+	}
+	for _, m := range pending {
+		if err := applyMigration(db, m); err != nil {
+			return nil, fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		slog.Info("applied migration", "version", m.Version, "description", m.Description)
+	}
+	return pending, nil
+}
+
+// applyMigration applies a migration and records its version in a single
+// transaction.
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	// PRAGMA doesn't support parameters.
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.Version)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// schemaVersion returns the version of a database and reports whether it's
+// derived from the schema of a database without a recorded version.
+func schemaVersion(db *sql.DB) (int, bool, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, false, err
+	}
+	if version > 0 {
+		return version, false, nil
+	}
+	version, err := legacySchemaVersion(db)
+	return version, version > 0, err
+}
+
+// legacySchemaVersion derives the version of a database without a recorded
+// version from the latest schema object it has. Earlier versions created their
+// whole schema at open time, so the latest object implies the rest.
+func legacySchemaVersion(db *sql.DB) (int, error) {
+	markers := []struct {
+		version int
+		query   string
+	}{
+		{6, `SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'index' AND name = 'cache_expires_at')`},
+		{5, `SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'table' AND name = 'cache_stats')`},
+		{4, `SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'table' AND name = 'not_found')`},
+		{3, `SELECT EXISTS (SELECT 1 FROM pragma_table_info('cache') WHERE name = 'etag')`},
+		{2, `SELECT EXISTS (SELECT 1 FROM pragma_table_info('cache') WHERE name = 'width')`},
+		{1, `SELECT EXISTS (SELECT 1 FROM sqlite_schema WHERE type = 'table' AND name = 'cache')`},
+	}
+	for _, marker := range markers {
+		var exists bool
+		if err := db.QueryRow(marker.query).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists {
+			return marker.version, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,121 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestPendingMigrationsMissingDatabase(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "missing.db")
+	if _, err := thumbnail.PendingMigrations(dsn); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("PendingMigrations() error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := os.Stat(dsn); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() error = %v, want the database not created", err)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       []string
+		wantPending int
+		wantErr     error
+	}{
+		{
+			name:        "new database",
+			wantPending: thumbnail.SchemaVersion,
+		},
+		{
+			// The schema created by the first version of the server.
+			name: "unversioned database",
+			setup: []string{
+				`CREATE TABLE cache (video_id TEXT PRIMARY KEY, content_type TEXT NOT NULL, data BLOB NOT NULL, expires_at INTEGER NOT NULL)`,
+				`INSERT INTO cache (video_id, content_type, data, expires_at) VALUES ('a', 'image/jpeg', x'6a706567', 4102444800)`,
+				`INSERT INTO cache (video_id, content_type, data, expires_at) VALUES ('c', 'image/jpeg', x'6a706567', 4102444800)`,
+				`INSERT INTO cache (video_id, content_type, data, expires_at) VALUES ('youtube:c', 'image/jpeg', x'6a706567', 4102444800)`,
+			},
+			wantPending: thumbnail.SchemaVersion - 1,
+		},
+		{
+			name:    "newer database",
+			setup:   []string{`PRAGMA user_version = 1000`},
+			wantErr: thumbnail.ErrSchemaTooNew,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn := filepath.Join(t.TempDir(), "cache.db")
+			db, err := sql.Open("sqlite3", dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Connecting creates the database file.
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+			for _, statement := range tt.setup {
+				if _, err := db.Exec(statement); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			pending, err := thumbnail.PendingMigrations(dsn)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PendingMigrations() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(pending) != tt.wantPending {
+				t.Errorf("PendingMigrations() = %d migrations, want %d", len(pending), tt.wantPending)
+			}
+			// A dry run doesn't apply anything.
+			if pending, _ := thumbnail.PendingMigrations(dsn); len(pending) != tt.wantPending {
+				t.Errorf("PendingMigrations() after a dry run = %d migrations, want %d", len(pending), tt.wantPending)
+			}
+
+			cache, err := thumbnail.OpenSQLiteCache(dsn, &sqliteConfig)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenSQLiteCache() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = cache.Close() })
+
+			if pending, err := thumbnail.PendingMigrations(dsn); err != nil || len(pending) != 0 {
+				t.Errorf("PendingMigrations() after migrating = %d migrations, %v, want none", len(pending), err)
+			}
+			if len(tt.setup) == 0 {
+				return
+			}
+
+			// Existing thumbnails survive under their namespaced keys and count
+			// towards the limits.
+			got, err := cache.GetThumbnail("youtube:a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Data, []byte("jpeg")) || !got.Expiration.Equal(time.Unix(4102444800, 0)) {
+				t.Errorf("GetThumbnail() = %+v, want the thumbnail stored before migrating", got)
+			}
+			cache.MaxEntries = 1
+			if err := cache.SetThumbnail("b", &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg")}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.GetThumbnail("youtube:a"); !errors.Is(err, thumbnail.ErrNotFound) {
+				t.Errorf("GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+			}
+		})
+	}
+}
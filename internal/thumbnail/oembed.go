@@ -0,0 +1,60 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxOEmbedSize is the max size of an oEmbed response body.
+	maxOEmbedSize = 1024 * 1024
+)
+
+// oEmbed is the subset of an oEmbed response (https://oembed.com) that is
+// needed to resolve a thumbnail.
+type oEmbed struct {
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// oEmbedThumbnailURL requests the oEmbed endpoint for a video URL and returns
+// the thumbnail URL from the response.
+// If the endpoint doesn't know the video or the video has no thumbnail, it
+// returns ErrNotFound. The response is not trusted, so the thumbnail URL must
+// have a given scheme and one of the given hosts, otherwise the server could
+// be made to request internal URLs.
+func oEmbedThumbnailURL(ctx context.Context, fetcher *Fetcher, endpoint, videoURL, scheme string, hosts []string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("url", videoURL)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	// Vimeo responds with 403 for private videos and 404 for missing ones.
+	var o oEmbed
+	if err := getJSON(ctx, fetcher, u.String(), maxOEmbedSize, &o, http.StatusForbidden); err != nil {
+		return "", err
+	}
+	if o.ThumbnailURL == "" {
+		return "", ErrNotFound
+	}
+
+	thumbnailURL, err := url.Parse(o.ThumbnailURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid oEmbed thumbnail URL: %w", err)
+	}
+	if !strings.EqualFold(thumbnailURL.Scheme, scheme) {
+		return "", fmt.Errorf("invalid oEmbed thumbnail URL scheme: %q", thumbnailURL.Scheme)
+	}
+	if !slices.Contains(hosts, strings.ToLower(thumbnailURL.Host)) {
+		return "", fmt.Errorf("oEmbed thumbnail URL host is not allowed: %q", thumbnailURL.Host)
+	}
+
+	return thumbnailURL.String(), nil
+}
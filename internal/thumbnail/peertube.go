@@ -0,0 +1,105 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// maxPeerTubeVideoIDLength is the max length of a PeerTube video ID. Both
+	// UUIDs and short UUIDs fit.
+	maxPeerTubeVideoIDLength = 36
+)
+
+// PeerTube is the PeerTube provider. PeerTube is federated, so only videos on
+// the configured instances are recognized. Its thumbnail URLs are resolved via
+// the oEmbed endpoint of the instance.
+//
+// Video IDs of the provider are prefixed by the instance host, e.g.
+// "framatube.org/9c9de5e8-0a1e-484a-b099-e80766180a6d".
+type PeerTube struct {
+	// instances are the base URLs of the known instances by lowercase host.
+	instances map[string]*url.URL
+}
+
+// NewPeerTube creates a new PeerTube provider for the given instance base
+// URLs, e.g. "https://framatube.org".
+func NewPeerTube(instances []string) (*PeerTube, error) {
+	p := &PeerTube{instances: make(map[string]*url.URL, len(instances))}
+	for _, instance := range instances {
+		u, err := url.Parse(instance)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PeerTube instance %q: %w", instance, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid PeerTube instance %q: absolute HTTP(S) URL is required", instance)
+		}
+		p.instances[strings.ToLower(u.Host)] = &url.URL{Scheme: u.Scheme, Host: u.Host}
+	}
+	return p, nil
+}
+
+// Name implements Provider.
+func (*PeerTube) Name() string {
+	return "peertube"
+}
+
+// Match implements Provider.
+func (p *PeerTube) Match(u *url.URL) bool {
+	_, ok := p.instances[strings.ToLower(u.Host)]
+	return ok
+}
+
+// VideoID implements Provider.
+//
+// Short (/w/ID), watch (/videos/watch/ID) and embed (/videos/embed/ID) URLs are
+// supported.
+func (p *PeerTube) VideoID(u *url.URL) (string, error) {
+	segments := pathSegments(u.Path)
+
+	var videoID string
+	switch {
+	case len(segments) == 2 && segments[0] == "w":
+		videoID = segments[1]
+	case len(segments) == 3 && segments[0] == "videos" && (segments[1] == "watch" || segments[1] == "embed"):
+		videoID = segments[2]
+	default:
+		return "", fmt.Errorf("%w: unknown PeerTube URL path: %s", ErrInvalidVideoURL, u.Path)
+	}
+
+	if videoID == "" || len(videoID) > maxPeerTubeVideoIDLength || !isPeerTubeVideoID(videoID) {
+		return "", fmt.Errorf("%w: %q: PeerTube video ID must be a UUID or a short UUID", ErrInvalidVideoID, videoID)
+	}
+	return strings.ToLower(u.Host) + "/" + videoID, nil
+}
+
+// ThumbnailURL implements Provider.
+func (p *PeerTube) ThumbnailURL(ctx context.Context, fetcher *Fetcher, videoID string) (string, error) {
+	host, id, ok := strings.Cut(videoID, "/")
+	if !ok {
+		return "", fmt.Errorf("%w: %q: instance host is missing", ErrInvalidVideoID, videoID)
+	}
+	instance, ok := p.instances[host]
+	if !ok {
+		return "", fmt.Errorf("%w: %q: unknown instance", ErrInvalidVideoID, videoID)
+	}
+
+	endpoint := instance.JoinPath("services", "oembed").String()
+	videoURL := instance.JoinPath("w", id).String()
+	// Thumbnails are served by the instance itself.
+	return oEmbedThumbnailURL(ctx, fetcher, endpoint, videoURL, instance.Scheme, []string{host})
+}
+
+// isPeerTubeVideoID reports whether s consists of characters allowed in UUIDs
+// and short (base58) UUIDs.
+func isPeerTubeVideoID(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrInvalidPlaylistURL is returned when a playlist URL is not a supported
+	// YouTube playlist or channel URL.
+	ErrInvalidPlaylistURL = errors.New("invalid playlist URL")
+	// ErrUnsupportedPlaylist is returned when a playlist resolver can't expand
+	// playlists of a given kind.
+	ErrUnsupportedPlaylist = errors.New("unsupported playlist")
+)
+
+// PlaylistKind is a kind of a YouTube playlist.
+type PlaylistKind int
+
+const (
+	// PlaylistKindPlaylist is a regular playlist identified by a playlist ID.
+	PlaylistKindPlaylist PlaylistKind = iota + 1
+	// PlaylistKindChannel is a channel identified by a channel ID (UC...).
+	PlaylistKindChannel
+	// PlaylistKindHandle is a channel identified by a handle without "@".
+	PlaylistKindHandle
+	// PlaylistKindUser is a channel identified by a legacy username.
+	PlaylistKindUser
+)
+
+// String returns the name of the kind.
+func (k PlaylistKind) String() string {
+	switch k {
+	case PlaylistKindPlaylist:
+		return "playlist"
+	case PlaylistKindChannel:
+		return "channel"
+	case PlaylistKindHandle:
+		return "handle"
+	case PlaylistKindUser:
+		return "user"
+	}
+	return fmt.Sprintf("PlaylistKind(%d)", int(k))
+}
+
+// Playlist is a YouTube playlist or channel whose videos can be expanded by a
+// PlaylistResolver.
+type Playlist struct {
+	// URL is the URL the playlist was parsed from.
+	URL  string
+	Kind PlaylistKind
+	ID   string
+}
+
+// PlaylistResolver expands playlists and channels into their videos.
+type PlaylistResolver interface {
+	// Resolve returns URLs of the videos of a playlist in playlist order.
+	// Resolvers that request remote APIs use the given fetcher.
+	// If the playlist doesn't exist, it returns ErrNotFound. If the resolver
+	// can't expand playlists of the given kind, it returns an error wrapping
+	// ErrUnsupportedPlaylist.
+	Resolve(ctx context.Context, fetcher *Fetcher, p Playlist) ([]string, error)
+}
+
+// ParsePlaylist parses a YouTube playlist or channel URL.
+//
+// Playlist (youtube.com/playlist?list=ID), watch URLs with a playlist
+// (youtube.com/watch?v=ID&list=ID), channel (youtube.com/channel/ID), handle
+// (youtube.com/@handle), custom (youtube.com/c/name) and legacy user
+// (youtube.com/user/name) URLs are supported. The scheme may be omitted.
+//
+// The returned error wraps ErrInvalidPlaylistURL.
+func ParsePlaylist(playlistURL string) (Playlist, error) {
+	u, err := parseURL(playlistURL)
+	if err != nil {
+		return Playlist{}, fmt.Errorf("%w: %w", ErrInvalidPlaylistURL, err)
+	}
+	if !youTubeHosts[strings.ToLower(u.Hostname())] {
+		return Playlist{}, fmt.Errorf("%w: unknown host: %q", ErrInvalidPlaylistURL, u.Host)
+	}
+
+	p := Playlist{URL: u.String()}
+	segments := pathSegments(u.Path)
+	switch {
+	case len(segments) == 1 && (segments[0] == "playlist" || segments[0] == "watch"):
+		q, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return Playlist{}, fmt.Errorf("%w: %w", ErrInvalidPlaylistURL, err)
+		}
+		p.Kind, p.ID = PlaylistKindPlaylist, q.Get("list")
+	case len(segments) >= 1 && strings.HasPrefix(segments[0], "@"):
+		p.Kind, p.ID = PlaylistKindHandle, strings.TrimPrefix(segments[0], "@")
+	case len(segments) >= 2 && segments[0] == "channel":
+		p.Kind, p.ID = PlaylistKindChannel, segments[1]
+	case len(segments) >= 2 && segments[0] == "c":
+		// YouTube redirects most custom URLs to the channel with the same
+		// handle.
+		p.Kind, p.ID = PlaylistKindHandle, segments[1]
+	case len(segments) >= 2 && segments[0] == "user":
+		p.Kind, p.ID = PlaylistKindUser, segments[1]
+	default:
+		return Playlist{}, fmt.Errorf("%w: unknown playlist URL path: %s", ErrInvalidPlaylistURL, u.Path)
+	}
+
+	if err := validatePlaylistID(p.Kind, p.ID); err != nil {
+		return Playlist{}, err
+	}
+	return p, nil
+}
+
+// validatePlaylistID checks that a playlist ID of a given kind is well-formed.
+// Validated IDs are safe to use in URL query parameters and path segments.
+func validatePlaylistID(kind PlaylistKind, id string) error {
+	valid := id != "" && len(id) <= 64
+	for i := 0; valid && i < len(id); i++ {
+		c := id[i]
+		valid = isVideoIDChar(c) || kind == PlaylistKindHandle && c == '.'
+	}
+	if kind == PlaylistKindChannel && !strings.HasPrefix(id, "UC") {
+		valid = false
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: malformed %s ID: %q", ErrInvalidPlaylistURL, kind, id)
+	}
+	return nil
+}
+
+// videoURLs returns the canonical URLs of YouTube videos with the given IDs.
+// At most maxVideos URLs are returned, IDs that are not valid video IDs are
+// skipped.
+func videoURLs(videoIDs []string, maxVideos int) []string {
+	urls := make([]string, 0, min(len(videoIDs), maxVideos))
+	for _, id := range videoIDs {
+		if len(urls) == maxVideos {
+			break
+		}
+		if ValidateVideoID(id) != nil {
+			continue
+		}
+		urls = append(urls, "https://www.youtube.com/watch?v="+id)
+	}
+	return urls
+}
@@ -0,0 +1,130 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+const (
+	// maxAPIResponseSize is the max size of a YouTube Data API response body.
+	maxAPIResponseSize = 4 * 1024 * 1024
+	// maxAPIPageSize is the max number of items per YouTube Data API page.
+	maxAPIPageSize = 50
+)
+
+// APIPlaylistResolver is a PlaylistResolver that uses the YouTube Data API v3.
+// It requires an API key and pages through entire playlists, channels are
+// expanded via their uploads playlists.
+type APIPlaylistResolver struct {
+	// URL is the base URL of the API, e.g.
+	// https://www.googleapis.com/youtube/v3.
+	URL string
+	// Key is the API key.
+	Key string
+	// MaxVideos is the max number of videos returned for a playlist.
+	MaxVideos int
+}
+
+// apiChannelList is the subset of a channels.list response that is needed to
+// find the uploads playlist of a channel.
+type apiChannelList struct {
+	Items []struct {
+		ContentDetails struct {
+			RelatedPlaylists struct {
+				Uploads string `json:"uploads"`
+			} `json:"relatedPlaylists"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+}
+
+// apiPlaylistItemList is the subset of a playlistItems.list response that is
+// needed to list video IDs.
+type apiPlaylistItemList struct {
+	NextPageToken string `json:"nextPageToken"`
+	Items         []struct {
+		ContentDetails struct {
+			VideoID string `json:"videoId"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+}
+
+// Resolve implements PlaylistResolver.
+func (r *APIPlaylistResolver) Resolve(ctx context.Context, fetcher *Fetcher, p Playlist) ([]string, error) {
+	var playlistID string
+	switch p.Kind {
+	case PlaylistKindPlaylist:
+		playlistID = p.ID
+	case PlaylistKindChannel:
+		// The uploads playlist of a channel has the same ID with a different
+		// prefix.
+		playlistID = "UU" + p.ID[len("UC"):]
+	case PlaylistKindHandle:
+		id, err := r.uploadsPlaylistID(ctx, fetcher, "forHandle", "@"+p.ID)
+		if err != nil {
+			return nil, err
+		}
+		playlistID = id
+	case PlaylistKindUser:
+		id, err := r.uploadsPlaylistID(ctx, fetcher, "forUsername", p.ID)
+		if err != nil {
+			return nil, err
+		}
+		playlistID = id
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedPlaylist, p.Kind)
+	}
+
+	var videoIDs []string
+	pageToken := ""
+	for len(videoIDs) < r.MaxVideos {
+		q := url.Values{
+			"part":       {"contentDetails"},
+			"playlistId": {playlistID},
+			"maxResults": {fmt.Sprint(min(maxAPIPageSize, r.MaxVideos-len(videoIDs)))},
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		var page apiPlaylistItemList
+		if err := getJSON(ctx, fetcher, r.endpoint("playlistItems", q), maxAPIResponseSize, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			videoIDs = append(videoIDs, item.ContentDetails.VideoID)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	return videoURLs(videoIDs, r.MaxVideos), nil
+}
+
+// uploadsPlaylistID returns the ID of the uploads playlist of a channel found by
+// a channels.list filter.
+func (r *APIPlaylistResolver) uploadsPlaylistID(ctx context.Context, fetcher *Fetcher, filter, value string) (string, error) {
+	var channels apiChannelList
+	err := getJSON(ctx, fetcher, r.endpoint("channels", url.Values{
+		"part": {"contentDetails"},
+		filter: {value},
+	}), maxAPIResponseSize, &channels)
+	if err != nil {
+		return "", err
+	}
+
+	if len(channels.Items) == 0 || channels.Items[0].ContentDetails.RelatedPlaylists.Uploads == "" {
+		return "", ErrNotFound
+	}
+	return channels.Items[0].ContentDetails.RelatedPlaylists.Uploads, nil
+}
+
+// endpoint returns a URL of an API resource with the given query and the API
+// key.
+func (r *APIPlaylistResolver) endpoint(resource string, q url.Values) string {
+	q.Set("key", r.Key)
+	return r.URL + "/" + resource + "?" + q.Encode()
+}
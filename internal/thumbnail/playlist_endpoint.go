@@ -0,0 +1,47 @@
+package thumbnail
+
+import (
+	"context"
+	"net/url"
+)
+
+// EndpointPlaylistResolver is a PlaylistResolver that delegates expansion to an
+// HTTP endpoint. The endpoint receives the playlist URL in the "url" query
+// parameter and responds with a JSON object like
+//
+//	{"video_urls": ["https://www.youtube.com/watch?v=dQw4w9WgXcQ"]}
+//
+// or 404 if the playlist doesn't exist. Video URLs may point to any supported
+// provider.
+type EndpointPlaylistResolver struct {
+	// URL is the URL of the endpoint.
+	URL string
+	// MaxVideos is the max number of videos returned for a playlist.
+	MaxVideos int
+}
+
+// endpointPlaylist is a response of a playlist endpoint.
+type endpointPlaylist struct {
+	VideoURLs []string `json:"video_urls"`
+}
+
+// Resolve implements PlaylistResolver.
+func (r *EndpointPlaylistResolver) Resolve(ctx context.Context, fetcher *Fetcher, p Playlist) ([]string, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("url", p.URL)
+	u.RawQuery = q.Encode()
+
+	var playlist endpointPlaylist
+	if err := getJSON(ctx, fetcher, u.String(), maxAPIResponseSize, &playlist); err != nil {
+		return nil, err
+	}
+
+	if len(playlist.VideoURLs) > r.MaxVideos {
+		playlist.VideoURLs = playlist.VideoURLs[:r.MaxVideos]
+	}
+	return playlist.VideoURLs, nil
+}
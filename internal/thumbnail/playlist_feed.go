@@ -0,0 +1,88 @@
+package thumbnail
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// maxFeedSize is the max size of a feed response body.
+	maxFeedSize = 4 * 1024 * 1024
+)
+
+// FeedPlaylistResolver is a PlaylistResolver that reads YouTube's public Atom
+// feeds. It needs no credentials but the feeds list only the latest 15 videos
+// of a playlist or a channel and can't resolve channel handles.
+type FeedPlaylistResolver struct {
+	// URL is the URL of the feeds, e.g.
+	// https://www.youtube.com/feeds/videos.xml.
+	URL string
+	// MaxVideos is the max number of videos returned for a playlist.
+	MaxVideos int
+}
+
+// youTubeFeed is the subset of a YouTube Atom feed that is needed to resolve
+// video IDs.
+type youTubeFeed struct {
+	Entries []struct {
+		VideoID string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	} `xml:"entry"`
+}
+
+// Resolve implements PlaylistResolver.
+func (r *FeedPlaylistResolver) Resolve(ctx context.Context, fetcher *Fetcher, p Playlist) ([]string, error) {
+	var param string
+	switch p.Kind {
+	case PlaylistKindPlaylist:
+		param = "playlist_id"
+	case PlaylistKindChannel:
+		param = "channel_id"
+	case PlaylistKindUser:
+		param = "user"
+	case PlaylistKindHandle:
+		return nil, fmt.Errorf("%w: feeds can't resolve channel handles", ErrUnsupportedPlaylist)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedPlaylist, p.Kind)
+	}
+
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set(param, p.ID)
+	u.RawQuery = q.Encode()
+
+	resp, err := fetcher.Get(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func(resp *http.Response) {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error("failed to close response body", "error", err)
+		}
+	}(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var feed youTubeFeed
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxFeedSize)).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to decode feed: %w", err)
+	}
+
+	videoIDs := make([]string, 0, len(feed.Entries))
+	for _, e := range feed.Entries {
+		videoIDs = append(videoIDs, e.VideoID)
+	}
+	return videoURLs(videoIDs, r.MaxVideos), nil
+}
@@ -0,0 +1,217 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+	"github.com/kirillgashkov/assignment-youthumb/proto/youthumbpb/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePlaylist(t *testing.T) {
+	tests := []struct {
+		name        string
+		playlistURL string
+		wantKind    thumbnail.PlaylistKind
+		wantID      string
+		wantErr     bool
+	}{
+		{name: "playlist", playlistURL: "https://www.youtube.com/playlist?list=PLBCF2DAC6FFB574DE", wantKind: thumbnail.PlaylistKindPlaylist, wantID: "PLBCF2DAC6FFB574DE"},
+		{name: "watch with list", playlistURL: "https://youtube.com/watch?v=dQw4w9WgXcQ&list=PLBCF2DAC6FFB574DE", wantKind: thumbnail.PlaylistKindPlaylist, wantID: "PLBCF2DAC6FFB574DE"},
+		{name: "channel", playlistURL: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", wantKind: thumbnail.PlaylistKindChannel, wantID: "UCuAXFkgsw1L7xaCfnd5JJOw"},
+		{name: "channel videos tab", playlistURL: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos", wantKind: thumbnail.PlaylistKindChannel, wantID: "UCuAXFkgsw1L7xaCfnd5JJOw"},
+		{name: "handle", playlistURL: "https://www.youtube.com/@RickAstleyYT", wantKind: thumbnail.PlaylistKindHandle, wantID: "RickAstleyYT"},
+		{name: "handle scheme-less", playlistURL: "youtube.com/@rick.astley/videos", wantKind: thumbnail.PlaylistKindHandle, wantID: "rick.astley"},
+		{name: "custom", playlistURL: "https://www.youtube.com/c/RickAstleyYT", wantKind: thumbnail.PlaylistKindHandle, wantID: "RickAstleyYT"},
+		{name: "user", playlistURL: "https://www.youtube.com/user/RickAstleyVEVO", wantKind: thumbnail.PlaylistKindUser, wantID: "RickAstleyVEVO"},
+		{name: "watch without list", playlistURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", wantErr: true},
+		{name: "channel without UC", playlistURL: "https://www.youtube.com/channel/abc", wantErr: true},
+		{name: "malformed ID", playlistURL: "https://www.youtube.com/playlist?list=PL%2F..%2Fx", wantErr: true},
+		{name: "unknown path", playlistURL: "https://www.youtube.com/feed/trending", wantErr: true},
+		{name: "unknown host", playlistURL: "https://example.com/playlist?list=PLBCF2DAC6FFB574DE", wantErr: true},
+		{name: "empty", playlistURL: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := thumbnail.ParsePlaylist(tt.playlistURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePlaylist() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				if !errors.Is(err, thumbnail.ErrInvalidPlaylistURL) {
+					t.Errorf("ParsePlaylist() error = %v, want ErrInvalidPlaylistURL", err)
+				}
+				return
+			}
+			if got.Kind != tt.wantKind || got.ID != tt.wantID {
+				t.Errorf("ParsePlaylist() got = %v %v, want %v %v", got.Kind, got.ID, tt.wantKind, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestFeedPlaylistResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("channel_id") != "UCuAXFkgsw1L7xaCfnd5JJOw" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml")
+		_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom">
+ <entry><yt:videoId>dQw4w9WgXcQ</yt:videoId></entry>
+ <entry><yt:videoId>yPYZpwSpKmA</yt:videoId></entry>
+ <entry><yt:videoId>../../x</yt:videoId></entry>
+ <entry><yt:videoId>AC3Ejf7vPEY</yt:videoId></entry>
+</feed>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	r := &thumbnail.FeedPlaylistResolver{URL: srv.URL, MaxVideos: 2}
+	ctx := context.Background()
+
+	got, err := r.Resolve(ctx, thumbnail.NewFetcherWithClient(srv.Client(), nil), thumbnail.Playlist{Kind: thumbnail.PlaylistKindChannel, ID: "UCuAXFkgsw1L7xaCfnd5JJOw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=yPYZpwSpKmA"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Resolve() got = %v, want %v", got, want)
+	}
+
+	_, err = r.Resolve(ctx, thumbnail.NewFetcherWithClient(srv.Client(), nil), thumbnail.Playlist{Kind: thumbnail.PlaylistKindChannel, ID: "UCxxxxxxxxxxxxxxxxxxxxxx"})
+	if !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("Resolve() error = %v, want ErrNotFound", err)
+	}
+
+	_, err = r.Resolve(ctx, thumbnail.NewFetcherWithClient(srv.Client(), nil), thumbnail.Playlist{Kind: thumbnail.PlaylistKindHandle, ID: "RickAstleyYT"})
+	if !errors.Is(err, thumbnail.ErrUnsupportedPlaylist) {
+		t.Errorf("Resolve() error = %v, want ErrUnsupportedPlaylist", err)
+	}
+}
+
+func TestAPIPlaylistResolver(t *testing.T) {
+	pages := map[string]string{
+		"":      `{"nextPageToken":"page2","items":[{"contentDetails":{"videoId":"dQw4w9WgXcQ"}},{"contentDetails":{"videoId":"yPYZpwSpKmA"}}]}`,
+		"page2": `{"items":[{"contentDetails":{"videoId":"AC3Ejf7vPEY"}}]}`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "secret" || r.URL.Query().Get("forHandle") != "@RickAstleyYT" {
+			_, _ = fmt.Fprint(w, `{"items":[]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"items":[{"contentDetails":{"relatedPlaylists":{"uploads":"UUuAXFkgsw1L7xaCfnd5JJOw"}}}]}`)
+	})
+	mux.HandleFunc("/playlistItems", func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Query().Get("pageToken")]
+		if r.URL.Query().Get("key") != "secret" || r.URL.Query().Get("playlistId") != "UUuAXFkgsw1L7xaCfnd5JJOw" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprint(w, page)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	r := &thumbnail.APIPlaylistResolver{URL: srv.URL, Key: "secret", MaxVideos: 10}
+	ctx := context.Background()
+	want := []string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://www.youtube.com/watch?v=yPYZpwSpKmA",
+		"https://www.youtube.com/watch?v=AC3Ejf7vPEY",
+	}
+
+	for _, p := range []thumbnail.Playlist{
+		{Kind: thumbnail.PlaylistKindHandle, ID: "RickAstleyYT"},
+		{Kind: thumbnail.PlaylistKindChannel, ID: "UCuAXFkgsw1L7xaCfnd5JJOw"},
+	} {
+		got, err := r.Resolve(ctx, thumbnail.NewFetcherWithClient(srv.Client(), nil), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Resolve(%v) got = %v, want %v", p.Kind, got, want)
+		}
+	}
+
+	_, err := r.Resolve(ctx, thumbnail.NewFetcherWithClient(srv.Client(), nil), thumbnail.Playlist{Kind: thumbnail.PlaylistKindHandle, ID: "unknown"})
+	if !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("Resolve() error = %v, want ErrNotFound", err)
+	}
+}
+
+// playlistStream is a fake server stream of the GetPlaylistThumbnails RPC.
+type playlistStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	chunks []*youthumbpb.PlaylistThumbnailChunk
+}
+
+func (s *playlistStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *playlistStream) Send(chunk *youthumbpb.PlaylistThumbnailChunk) error {
+	s.chunks = append(s.chunks, chunk)
+	return nil
+}
+
+func TestServiceGetPlaylistThumbnails(t *testing.T) {
+	jpegData := newJPEG(t, 480, 360)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/playlist", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") != "https://www.youtube.com/playlist?list=PLBCF2DAC6FFB574DE" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"video_urls":["https://dai.ly/x7tgad0","https://dai.ly/x0000000","https://example.com/x"]}`)
+	})
+	mux.HandleFunc("/thumbnail/video/x7tgad0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write(jpegData)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resolver := &thumbnail.EndpointPlaylistResolver{URL: srv.URL + "/playlist", MaxVideos: 10}
+	s, _ := newTestService(t, srv, withProviders(thumbnail.Providers{&thumbnail.Dailymotion{BaseURL: srv.URL}}), withResolver(resolver))
+
+	stream := &playlistStream{ctx: context.Background()}
+	req := &youthumbpb.GetPlaylistThumbnailsRequest{PlaylistUrl: "https://www.youtube.com/playlist?list=PLBCF2DAC6FFB574DE"}
+	if err := s.GetPlaylistThumbnails(req, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stream.chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(stream.chunks))
+	}
+	if c := stream.chunks[0]; c.VideoUrl != "https://dai.ly/x7tgad0" || !bytes.Equal(c.Chunk.GetData(), jpegData) || c.Chunk.GetContentType() != "image/jpeg" {
+		t.Errorf("chunk 0 = %v, want thumbnail of x7tgad0", c)
+	}
+	if c := stream.chunks[1]; c.VideoUrl != "https://dai.ly/x0000000" || codes.Code(c.ErrorCode) != codes.NotFound {
+		t.Errorf("chunk 1 = %v, want NotFound error", c)
+	}
+	if c := stream.chunks[2]; c.VideoUrl != "https://example.com/x" || codes.Code(c.ErrorCode) != codes.InvalidArgument {
+		t.Errorf("chunk 2 = %v, want InvalidArgument error", c)
+	}
+
+	req = &youthumbpb.GetPlaylistThumbnailsRequest{PlaylistUrl: "https://www.youtube.com/playlist?list=PLunknown"}
+	if err := s.GetPlaylistThumbnails(req, &playlistStream{ctx: context.Background()}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetPlaylistThumbnails() error = %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,371 @@
+package thumbnail
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+)
+
+// PostgresCache is a cache for thumbnail images stored in a Postgres
+// database, which several server replicas may share.
+//
+// Like SQLiteCache, the cache tracks the last access time and the number of
+// hits of every thumbnail, and accesses are buffered and recorded in batches.
+// If a write makes the cache exceed MaxSize or MaxEntries, thumbnails other
+// than the written one are evicted by the Eviction policy once the write is
+// committed, so that writes within the limits don't wait for each other
+// beyond updating the totals. Replicas evicting at once may evict a few more
+// thumbnails than needed.
+//
+// If Blobs is set, thumbnail data is stored in the blob store and the database
+// only references it by hash. The blob store must be shared between the
+// replicas too, e.g. an S3BlobStore.
+type PostgresCache struct {
+	// MaxSize is the max total size of thumbnail data in bytes, zero means no
+	// limit.
+	MaxSize int64
+	// MaxEntries is the max number of thumbnails, zero means no limit.
+	MaxEntries int64
+	// Eviction is the eviction policy, EvictionLRU or EvictionLFU. Empty
+	// means EvictionLRU.
+	Eviction string
+	// Blobs is the store of thumbnail data. If nil, thumbnail data is stored
+	// in the database. Thumbnails stored in either way are read regardless.
+	Blobs BlobStore
+
+	// db is the Postgres database connection pool.
+	db       *sql.DB
+	accesses *accessLog
+}
+
+// OpenPostgresCache opens a new Postgres cache with the connection settings
+// of a given config. The schema of the database is migrated to
+// PostgresSchemaVersion. If the database was migrated by a newer version, it
+// returns an error wrapping ErrSchemaTooNew.
+// The given DSN must be a Postgres DSN, e.g. "postgres://host/db".
+func OpenPostgresCache(dsn string, cfg *config.PostgresConfig) (*PostgresCache, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	if _, err := migratePostgres(db, false); err != nil {
+		return nil, errors.Join(err, db.Close())
+	}
+
+	c := &PostgresCache{db: db}
+	c.accesses = newAccessLog(c.writeAccesses)
+	return c, nil
+}
+
+// PendingPostgresMigrations returns the migrations OpenPostgresCache would
+// apply to a database without applying them.
+// The given DSN must be a Postgres DSN.
+func PendingPostgresMigrations(dsn string) ([]Migration, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := migratePostgres(db, true)
+	return pending, errors.Join(err, db.Close())
+}
+
+// Close implements Cache. It records the buffered accesses first.
+func (c *PostgresCache) Close() error {
+	return errors.Join(c.accesses.close(), c.db.Close())
+}
+
+// GetThumbnail implements Cache. A thumbnail whose blob is missing is not
+// found.
+func (c *PostgresCache) GetThumbnail(videoID string) (*Thumbnail, error) {
+	t, hash, err := c.get(videoID)
+	if err != nil || hash == "" {
+		return t, err
+	}
+	if t.Data, err = readBlob(c.Blobs, videoID, hash); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// OpenThumbnail implements ThumbnailOpener. The data of a thumbnail stored in
+// the blob store is streamed from it.
+func (c *PostgresCache) OpenThumbnail(ctx context.Context, videoID string) (*Thumbnail, io.ReadCloser, error) {
+	t, hash, err := c.get(videoID)
+	if err != nil || hash == "" {
+		return t, nil, err
+	}
+	body, err := openBlob(ctx, c.Blobs, videoID, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, body, nil
+}
+
+// get returns a thumbnail and the hash of its blob, if any, and buffers the
+// access.
+func (c *PostgresCache) get(videoID string) (*Thumbnail, string, error) {
+	query := `
+		SELECT content_type, data, expires_at, width, height, etag, last_modified, blob_hash
+		FROM cache WHERE video_id = $1
+	`
+	t := &Thumbnail{}
+	var expiration int64
+	var hash string
+	err := c.db.QueryRow(query, videoID).Scan(
+		&t.ContentType, &t.Data, &expiration, &t.Width, &t.Height, &t.ETag, &t.LastModified, &hash,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	t.Expiration = time.Unix(expiration, 0)
+	c.accesses.record(videoID, time.Now())
+	return t, hash, nil
+}
+
+// RecordAccess implements AccessRecorder.
+func (c *PostgresCache) RecordAccess(videoID string, at time.Time) {
+	c.accesses.record(videoID, at)
+}
+
+// writeAccesses records a batch of accesses by a single statement. The rows
+// are locked in the order of their video IDs, so that replicas recording
+// accesses at once don't deadlock.
+func (c *PostgresCache) writeAccesses(accesses map[string]access) error {
+	videoIDs := make([]string, 0, len(accesses))
+	for videoID := range accesses {
+		videoIDs = append(videoIDs, videoID)
+	}
+	slices.Sort(videoIDs)
+	accessedAt := make([]int64, len(videoIDs))
+	hits := make([]int64, len(videoIDs))
+	for i, videoID := range videoIDs {
+		accessedAt[i], hits[i] = accesses[videoID].at, accesses[videoID].hits
+	}
+
+	query := `
+		WITH locked AS (
+			SELECT video_id FROM cache WHERE video_id = ANY($1::text[]) ORDER BY video_id FOR UPDATE
+		)
+		UPDATE cache SET accessed_at = GREATEST(cache.accessed_at, a.accessed_at), hits = cache.hits + a.hits
+		FROM locked JOIN unnest($1::text[], $2::bigint[], $3::bigint[]) AS a (video_id, accessed_at, hits) USING (video_id)
+		WHERE cache.video_id = a.video_id
+	`
+	_, err := c.db.Exec(query, pq.Array(videoIDs), pq.Array(accessedAt), pq.Array(hits))
+	return err
+}
+
+// SetThumbnail implements Cache. A replaced thumbnail keeps its hits. If the
+// cache exceeds its limits, other thumbnails are evicted.
+func (c *PostgresCache) SetThumbnail(videoID string, t *Thumbnail) error {
+	// The blob is written before the upsert, so that the totals aren't locked
+	// during blob store writes.
+	data, hash := t.Data, ""
+	if c.Blobs != nil {
+		var err error
+		if hash, err = c.Blobs.Put(t); err != nil {
+			return err
+		}
+		data = []byte{}
+	}
+
+	// The upsert is a transaction of its own: the trigger locks the totals at
+	// the end of the statement, after the row, and the lock is released right
+	// away by the commit.
+	query := `
+		INSERT INTO cache (video_id, content_type, data, expires_at, width, height, etag, last_modified, accessed_at, size, blob_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (video_id) DO UPDATE SET
+			content_type = excluded.content_type,
+			data = excluded.data,
+			expires_at = excluded.expires_at,
+			width = excluded.width,
+			height = excluded.height,
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			accessed_at = excluded.accessed_at,
+			size = excluded.size,
+			blob_hash = excluded.blob_hash
+	`
+	_, err := c.db.Exec(query, videoID, t.ContentType, data, t.Expiration.Unix(), t.Width, t.Height, t.ETag, t.LastModified, time.Now().UnixMilli(), len(t.Data), hash)
+	if err != nil {
+		return err
+	}
+
+	// The thumbnail is written regardless of the eviction, a failed eviction
+	// is retried by the next write.
+	n, err := c.evict(videoID)
+	if err != nil {
+		slog.Error("failed to evict thumbnails", "error", err)
+	}
+	evictions.Add(n)
+	return nil
+}
+
+// evict deletes thumbnails other than keptVideoID by the eviction policy until
+// the cache fits its limits and returns the number of deleted thumbnails.
+//
+// The victims are deleted by a single statement, which locks their rows before
+// the trigger locks the totals at its end, like the upserts do, so evictions
+// and writes never wait for each other in a cycle.
+func (c *PostgresCache) evict(keptVideoID string) (int64, error) {
+	if c.MaxSize <= 0 && c.MaxEntries <= 0 {
+		return 0, nil
+	}
+
+	var entries, size int64
+	if err := c.db.QueryRow(`SELECT entries, bytes FROM cache_stats`).Scan(&entries, &size); err != nil {
+		return 0, err
+	}
+	excessEntries, excessSize := int64(0), int64(0)
+	if c.MaxEntries > 0 {
+		excessEntries = entries - c.MaxEntries
+	}
+	if c.MaxSize > 0 {
+		excessSize = size - c.MaxSize
+	}
+	if excessEntries <= 0 && excessSize <= 0 {
+		return 0, nil
+	}
+
+	// The buffered accesses are recorded first, so that the eviction policy
+	// sees them.
+	if err := c.accesses.flush(); err != nil {
+		slog.Error("failed to record thumbnail accesses", "error", err)
+	}
+
+	// A thumbnail is a victim while the thumbnails before it in the eviction
+	// order don't cover the excess.
+	order := "accessed_at, video_id"
+	if c.Eviction == EvictionLFU {
+		order = "hits, accessed_at, video_id"
+	}
+	query := `
+		DELETE FROM cache WHERE video_id IN (
+			SELECT video_id FROM (
+				SELECT video_id, ROW_NUMBER() OVER w - 1 AS entries_before, SUM(size) OVER w - size AS bytes_before
+				FROM cache WHERE video_id != $1
+				WINDOW w AS (ORDER BY ` + order + ` ROWS UNBOUNDED PRECEDING)
+			) ranked
+			WHERE entries_before < $2 OR bytes_before < $3
+		)
+	`
+	res, err := c.db.Exec(query, keptVideoID, excessEntries, excessSize)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ExtendThumbnail implements Cache.
+func (c *PostgresCache) ExtendThumbnail(videoID string, t *Thumbnail) error {
+	query := `UPDATE cache SET expires_at = $1, etag = $2, last_modified = $3 WHERE video_id = $4`
+
+	res, err := c.db.Exec(query, t.Expiration.Unix(), t.ETag, t.LastModified, videoID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// IsNotFound implements Cache.
+func (c *PostgresCache) IsNotFound(videoID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM not_found WHERE video_id = $1 AND expires_at > $2)`
+	if err := c.db.QueryRow(query, videoID, time.Now().Unix()).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SetNotFound implements Cache.
+func (c *PostgresCache) SetNotFound(videoID string, expiration time.Time) error {
+	query := `
+		INSERT INTO not_found (video_id, expires_at) VALUES ($1, $2)
+		ON CONFLICT (video_id) DO UPDATE SET expires_at = excluded.expires_at
+	`
+
+	if _, err := c.db.Exec(query, videoID, expiration.Unix()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ClearNotFound implements Cache.
+func (c *PostgresCache) ClearNotFound() (int64, error) {
+	res, err := c.db.Exec(`DELETE FROM not_found`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpired implements Sweeper. Like evictions, the expired thumbnails are
+// deleted by a single statement.
+func (c *PostgresCache) DeleteExpired(before time.Time, limit int) (int64, int64, error) {
+	query := `
+		DELETE FROM cache WHERE video_id IN (SELECT video_id FROM cache WHERE expires_at < $1 LIMIT $2)
+		RETURNING size
+	`
+	rows, err := c.db.Query(query, before.Unix(), limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	var n, size int64
+	for rows.Next() {
+		var rowSize int64
+		if err := rows.Scan(&rowSize); err != nil {
+			return 0, 0, errors.Join(err, rows.Close())
+		}
+		n++
+		size += rowSize
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return 0, 0, err
+	}
+
+	query = `DELETE FROM not_found WHERE video_id IN (SELECT video_id FROM not_found WHERE expires_at < $1 LIMIT $2)`
+	res, err := c.db.Exec(query, before.Unix(), limit)
+	if err != nil {
+		return n, size, err
+	}
+	notFound, err := res.RowsAffected()
+	if err != nil {
+		return n, size, err
+	}
+
+	return n + notFound, size, nil
+}
+
+// CollectBlobs deletes the blobs modified before a given time that no
+// thumbnail references, see SQLiteCache.CollectBlobs. Replicas sharing the
+// blob store may collect it concurrently. If Blobs is nil, it does nothing.
+func (c *PostgresCache) CollectBlobs(ctx context.Context, before time.Time) (int64, int64, error) {
+	if c.Blobs == nil {
+		return 0, 0, nil
+	}
+	return collectBlobs(ctx, c.db, c.Blobs, before)
+}
@@ -0,0 +1,188 @@
+package thumbnail
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// postgresMigrationLock is the key of the advisory lock that serializes
+// migrations of replicas starting at once.
+const postgresMigrationLock = 0x796f757468756d62
+
+// postgresMigrations are the migrations of the Postgres cache schema in order.
+// A migration must never change once released, schema changes are added as
+// new migrations.
+var postgresMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create cache tables",
+		statements: []string{
+			`
+			CREATE TABLE cache (
+				video_id text PRIMARY KEY,
+				content_type text NOT NULL,
+				data bytea NOT NULL,
+				expires_at bigint NOT NULL,
+				width integer NOT NULL DEFAULT 0,
+				height integer NOT NULL DEFAULT 0,
+				etag text NOT NULL DEFAULT '',
+				last_modified text NOT NULL DEFAULT '',
+				accessed_at bigint NOT NULL DEFAULT 0,
+				hits bigint NOT NULL DEFAULT 0,
+				size bigint NOT NULL DEFAULT 0
+			)
+			`,
+			`CREATE INDEX cache_lru ON cache (accessed_at)`,
+			`CREATE INDEX cache_lfu ON cache (hits, accessed_at)`,
+			`CREATE INDEX cache_expires_at ON cache (expires_at)`,
+			`
+			CREATE TABLE not_found (
+				video_id text PRIMARY KEY,
+				expires_at bigint NOT NULL
+			)
+			`,
+			`CREATE INDEX not_found_expires_at ON not_found (expires_at)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "track cache totals",
+		statements: []string{
+			// cache_stats keeps the totals of the cache table up to date, so
+			// that the limits are checked without scanning the table. The
+			// trigger updates its only row at the end of every statement
+			// that changes the totals.
+			`
+			CREATE TABLE cache_stats (
+				id integer PRIMARY KEY CHECK (id = 0),
+				entries bigint NOT NULL,
+				bytes bigint NOT NULL
+			)
+			`,
+			`INSERT INTO cache_stats (id, entries, bytes) SELECT 0, COUNT(*), COALESCE(SUM(size), 0) FROM cache`,
+			`
+			CREATE FUNCTION cache_stats_update() RETURNS trigger LANGUAGE plpgsql AS $$
+			BEGIN
+				IF TG_OP = 'INSERT' THEN
+					UPDATE cache_stats SET entries = entries + 1, bytes = bytes + NEW.size;
+				ELSIF TG_OP = 'UPDATE' THEN
+					UPDATE cache_stats SET bytes = bytes - OLD.size + NEW.size;
+				ELSE
+					UPDATE cache_stats SET entries = entries - 1, bytes = bytes - OLD.size;
+				END IF;
+				RETURN NULL;
+			END
+			$$
+			`,
+			`
+			CREATE TRIGGER cache_stats_update AFTER INSERT OR DELETE OR UPDATE OF size ON cache
+			FOR EACH ROW EXECUTE FUNCTION cache_stats_update()
+			`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "reference thumbnail data in blob stores",
+		statements: []string{
+			`ALTER TABLE cache ADD COLUMN blob_hash text NOT NULL DEFAULT ''`,
+			`CREATE INDEX cache_blob_hash ON cache (blob_hash) WHERE blob_hash != ''`,
+		},
+	},
+}
+
+// PostgresSchemaVersion is the version of the Postgres cache schema the
+// running server migrates databases to.
+var PostgresSchemaVersion = postgresMigrations[len(postgresMigrations)-1].Version
+
+// migratePostgres applies the pending migrations to a database, each in its
+// own transaction. If dryRun is true, it only returns the pending migrations.
+//
+// The versions of applied migrations are recorded in the schema_migrations
+// table. Replicas starting at once apply every migration once.
+func migratePostgres(db *sql.DB, dryRun bool) ([]Migration, error) {
+	version, err := postgresSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version > PostgresSchemaVersion {
+		return nil, fmt.Errorf("%w: version %d, supported %d", ErrSchemaTooNew, version, PostgresSchemaVersion)
+	}
+
+	pending := postgresMigrations[version:]
+	if dryRun {
+		return pending, nil
+	}
+	for _, m := range pending {
+		applied, err := applyPostgresMigration(db, m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		if applied {
+			slog.Info("applied migration", "version", m.Version, "description", m.Description)
+		}
+	}
+	return pending, nil
+}
+
+// applyPostgresMigration applies a migration and records its version in a
+// single transaction. It reports whether the migration was applied, i.e. not
+// applied by another replica in the meantime.
+func applyPostgresMigration(db *sql.DB, m Migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer rollback(tx)
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return false, err
+	}
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			description text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)
+	`
+	if _, err := tx.Exec(query); err != nil {
+		return false, err
+	}
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return false, err
+	}
+	if version >= m.Version {
+		return false, nil
+	}
+
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return false, err
+		}
+	}
+	query = `INSERT INTO schema_migrations (version, description) VALUES ($1, $2)`
+	if _, err := tx.Exec(query, m.Version, m.Description); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// postgresSchemaVersion returns the version of a database, zero if it has no
+// schema_migrations table.
+func postgresSchemaVersion(db *sql.DB) (int, error) {
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
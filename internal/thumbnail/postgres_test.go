@@ -0,0 +1,386 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+// postgresDSNEnv is the environment variable with the DSN of the Postgres
+// database of the integration tests, e.g. the one of `docker compose --profile
+// postgres up`. The tests are skipped if it's empty.
+const postgresDSNEnv = "APP_TEST_POSTGRES_DSN"
+
+// postgresConfig is the Postgres config of the tests, the default config of
+// the server.
+var postgresConfig = config.PostgresConfig{MaxConns: 16, MaxIdleConns: 4, ConnMaxLifetime: 30 * time.Minute}
+
+// newPostgresDSN returns the DSN of an empty schema of the integration test
+// database, which is dropped once the test finishes.
+func newPostgresDSN(t *testing.T) string {
+	t.Helper()
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Error(err)
+		}
+		_ = db.Close()
+	})
+
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+func openPostgresCache(t *testing.T) *thumbnail.PostgresCache {
+	t.Helper()
+	cache, err := thumbnail.OpenPostgresCache(newPostgresDSN(t), &postgresConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache
+}
+
+func TestPostgresCache(t *testing.T) {
+	cache := openPostgresCache(t)
+
+	expiration := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	want := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: expiration, Width: 160, Height: 90, ETag: `"v1"`}
+	if _, err := cache.GetThumbnail("a"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+	if err := cache.SetThumbnail("a", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cache.GetThumbnail("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data, want.Data) || !got.Expiration.Equal(want.Expiration) || got.ContentType != want.ContentType ||
+		got.Width != want.Width || got.Height != want.Height || got.ETag != want.ETag {
+		t.Errorf("GetThumbnail() = %+v, want %+v", got, want)
+	}
+
+	// Upsert.
+	replaced := &thumbnail.Thumbnail{ContentType: "image/png", Data: []byte("png"), Expiration: expiration}
+	if err := cache.SetThumbnail("a", replaced); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.GetThumbnail("a"); err != nil || !bytes.Equal(got.Data, replaced.Data) || got.ContentType != replaced.ContentType {
+		t.Errorf("GetThumbnail() = %+v, %v, want %+v", got, err, replaced)
+	}
+
+	extended := &thumbnail.Thumbnail{Expiration: expiration.Add(time.Hour), ETag: `"v2"`}
+	if err := cache.ExtendThumbnail("a", extended); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.GetThumbnail("a"); err != nil || !got.Expiration.Equal(extended.Expiration) || got.ETag != extended.ETag {
+		t.Errorf("GetThumbnail() = %+v, %v, want the extended thumbnail", got, err)
+	}
+	if err := cache.ExtendThumbnail("b", extended); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("ExtendThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+
+	for videoID, expiration := range map[string]time.Time{"c": time.Now().Add(time.Hour), "d": time.Now().Add(-time.Second)} {
+		if err := cache.SetNotFound(videoID, expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.SetNotFound("c", time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for videoID, want := range map[string]bool{"a": false, "c": true, "d": false} {
+		if got, err := cache.IsNotFound(videoID); err != nil || got != want {
+			t.Errorf("IsNotFound(%q) = %v, %v, want %v", videoID, got, err, want)
+		}
+	}
+	if n, err := cache.ClearNotFound(); err != nil || n != 2 {
+		t.Errorf("ClearNotFound() = %d, %v, want 2", n, err)
+	}
+}
+
+func TestPostgresCacheDeleteExpired(t *testing.T) {
+	cache := openPostgresCache(t)
+
+	now := time.Now()
+	for videoID, expiration := range map[string]time.Time{"fresh": now.Add(time.Hour), "expired 1": now.Add(-time.Hour), "expired 2": now.Add(-time.Hour)} {
+		th := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: expiration}
+		if err := cache.SetThumbnail(videoID, th); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.SetNotFound("not found", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, size, err := cache.DeleteExpired(now, 10)
+	if err != nil || n != 3 || size != 2*int64(len("jpeg")) {
+		t.Errorf("DeleteExpired() = %d, %d, %v, want 3, %d", n, size, err, 2*len("jpeg"))
+	}
+	if _, err := cache.GetThumbnail("fresh"); err != nil {
+		t.Errorf("GetThumbnail() error = %v", err)
+	}
+}
+
+func TestPostgresCacheEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		eviction string
+		want     map[string]bool
+	}{
+		// "a" is the most frequently used, "b" is the most recently used.
+		{name: "lru", eviction: thumbnail.EvictionLRU, want: map[string]bool{"a": false, "b": true, "c": true}},
+		{name: "lfu", eviction: thumbnail.EvictionLFU, want: map[string]bool{"a": true, "b": false, "c": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := openPostgresCache(t)
+			cache.MaxEntries = 2
+			cache.Eviction = tt.eviction
+
+			set := func(key string) {
+				t.Helper()
+				if err := cache.SetThumbnail(key, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte(key)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			get := func(key string) {
+				t.Helper()
+				if _, err := cache.GetThumbnail(key); err != nil {
+					t.Fatal(err)
+				}
+				// Access times have millisecond precision.
+				time.Sleep(2 * time.Millisecond)
+			}
+
+			set("a")
+			get("a")
+			get("a")
+			set("b")
+			get("b")
+			set("c")
+
+			for key, want := range tt.want {
+				_, err := cache.GetThumbnail(key)
+				if got := err == nil; got != want {
+					t.Errorf("GetThumbnail(%q) error = %v, want found %v", key, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresCacheAccesses(t *testing.T) {
+	dsn := newPostgresDSN(t)
+	var replicas []*thumbnail.PostgresCache
+	for range 2 {
+		cache, err := thumbnail.OpenPostgresCache(dsn, &postgresConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, cache)
+	}
+	if err := replicas[0].SetThumbnail("a", &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The accesses of every replica are recorded once it's closed at the
+	// latest.
+	for _, cache := range replicas {
+		for range 3 {
+			if _, err := cache.GetThumbnail("a"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cache.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	var hits int
+	if err := db.QueryRow(`SELECT hits FROM cache WHERE video_id = 'a'`).Scan(&hits); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 6 {
+		t.Errorf("got %d hits, want 6", hits)
+	}
+}
+
+func TestPostgresCacheConcurrentEviction(t *testing.T) {
+	const (
+		writers    = 8
+		writes     = 50
+		maxEntries = 20
+		dataSize   = 1000
+		maxSize    = 15 * dataSize
+	)
+
+	cache := openPostgresCache(t)
+	cache.MaxEntries = maxEntries
+	cache.MaxSize = maxSize
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers*writes)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writes {
+				videoID := fmt.Sprintf("%d-%d", w, i)
+				if err := cache.SetThumbnail(videoID, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: make([]byte, dataSize)}); err != nil {
+					errs <- err
+				}
+				if _, err := cache.GetThumbnail(videoID); err != nil && !errors.Is(err, thumbnail.ErrNotFound) {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	var entries, size int
+	for w := range writers {
+		for i := range writes {
+			got, err := cache.GetThumbnail(fmt.Sprintf("%d-%d", w, i))
+			if errors.Is(err, thumbnail.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries++
+			size += len(got.Data)
+		}
+	}
+	if entries == 0 || entries > maxEntries || size > maxSize {
+		t.Errorf("got %d entries of %d bytes, want 1-%d entries of at most %d bytes", entries, size, maxEntries, maxSize)
+	}
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	dsn := newPostgresDSN(t)
+
+	pending, err := thumbnail.PendingPostgresMigrations(dsn)
+	if err != nil || len(pending) != thumbnail.PostgresSchemaVersion {
+		t.Fatalf("PendingPostgresMigrations() = %d migrations, %v, want %d", len(pending), err, thumbnail.PostgresSchemaVersion)
+	}
+
+	// Replicas starting at once migrate the database once.
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache, err := thumbnail.OpenPostgresCache(dsn, &postgresConfig)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- cache.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending, err := thumbnail.PendingPostgresMigrations(dsn); err != nil || len(pending) != 0 {
+		t.Errorf("PendingPostgresMigrations() after migrating = %d migrations, %v, want none", len(pending), err)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, description) VALUES (1000, 'future')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thumbnail.OpenPostgresCache(dsn, &postgresConfig); !errors.Is(err, thumbnail.ErrSchemaTooNew) {
+		t.Errorf("OpenPostgresCache() error = %v, want %v", err, thumbnail.ErrSchemaTooNew)
+	}
+}
+
+func TestPostgresCacheBlobs(t *testing.T) {
+	cache := openPostgresCache(t)
+
+	// A thumbnail stored in the database is read after a blob store is set.
+	if err := cache.SetThumbnail("inline", &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("inline")}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	blobs, err := thumbnail.NewFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Blobs = blobs
+
+	for _, videoID := range []string{"a", "b"} {
+		if err := cache.SetThumbnail(videoID, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("same")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for videoID, want := range map[string]string{"inline": "inline", "a": "same", "b": "same"} {
+		got, err := cache.GetThumbnail(videoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Data) != want {
+			t.Errorf("GetThumbnail(%q) data = %q, want %q", videoID, got.Data, want)
+		}
+	}
+	if n := countBlobs(t, dir); n != 1 {
+		t.Errorf("got %d blobs, want identical thumbnails stored once", n)
+	}
+
+	// Replacing both thumbnails orphans the shared blob.
+	for _, videoID := range []string{"a", "b"} {
+		if err := cache.SetThumbnail(videoID, &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte(videoID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, _, err := cache.CollectBlobs(context.Background(), time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("CollectBlobs() = %d, %v, want 1", n, err)
+	}
+	if n := countBlobs(t, dir); n != 2 {
+		t.Errorf("got %d blobs, want 2", n)
+	}
+}
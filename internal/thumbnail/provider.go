@@ -0,0 +1,92 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+)
+
+// Provider is a video hosting provider, e.g. YouTube or Vimeo.
+type Provider interface {
+	// Name returns a short unique name of the provider, e.g. "youtube". The
+	// name is used to namespace video IDs.
+	Name() string
+
+	// Match reports whether the URL points to a video hosted by the provider.
+	Match(u *url.URL) bool
+
+	// VideoID extracts a video ID from a URL accepted by Match.
+	// The returned error wraps ErrInvalidVideoURL or ErrInvalidVideoID.
+	VideoID(u *url.URL) (string, error)
+
+	// ThumbnailURL returns a URL of a thumbnail for a video ID returned by
+	// VideoID. Providers that resolve the URL remotely (e.g. via oEmbed) use
+	// the given fetcher.
+	// If the video doesn't exist, it returns ErrNotFound.
+	ThumbnailURL(ctx context.Context, fetcher *Fetcher, videoID string) (string, error)
+}
+
+// PlaceholderDetector is implemented by providers that serve placeholder images
+// instead of the thumbnails of some missing videos.
+type PlaceholderDetector interface {
+	// IsPlaceholder reports whether a thumbnail downloaded from a URL is a
+	// placeholder rather than a thumbnail of a video.
+	IsPlaceholder(thumbnailURL string, t *Thumbnail) bool
+}
+
+// Video is a video hosted by a provider.
+type Video struct {
+	Provider Provider
+	ID       string
+}
+
+// Key returns the video ID namespaced by the provider name, e.g.
+// "youtube:dQw4w9WgXcQ". Keys are unique across providers and are used as
+// cache keys.
+func (v Video) Key() string {
+	return v.Provider.Name() + ":" + v.ID
+}
+
+// Providers is an ordered list of providers.
+type Providers []Provider
+
+// NewProviders returns the list of all supported providers configured by the
+// config. PeerTube videos are recognized only on the configured instances, see
+// NewPeerTube.
+func NewProviders(cfg *config.Config) (Providers, error) {
+	peerTube, err := NewPeerTube(cfg.PeerTube.Instances)
+	if err != nil {
+		return nil, err
+	}
+	youTube := NewYouTube()
+	youTube.URLTemplate = cfg.Upstream.ThumbnailURLTemplate
+	youTube.PlaceholderHashes = cfg.Upstream.PlaceholderHashes
+	return Providers{youTube, NewVimeo(), NewDailymotion(), peerTube}, nil
+}
+
+// Parse finds the first provider that matches a video URL and extracts the
+// video from the URL. The scheme of the URL may be omitted.
+//
+// The returned error wraps ErrInvalidVideoURL if no provider matches the URL
+// and ErrInvalidVideoID if the video ID in the URL is missing or malformed.
+func (ps Providers) Parse(videoURL string) (Video, error) {
+	u, err := parseURL(videoURL)
+	if err != nil {
+		return Video{}, fmt.Errorf("%w: %w", ErrInvalidVideoURL, err)
+	}
+
+	for _, p := range ps {
+		if !p.Match(u) {
+			continue
+		}
+		videoID, err := p.VideoID(u)
+		if err != nil {
+			return Video{}, err
+		}
+		return Video{Provider: p, ID: videoID}, nil
+	}
+
+	return Video{}, fmt.Errorf("%w: unknown host: %q", ErrInvalidVideoURL, u.Host)
+}
@@ -0,0 +1,225 @@
+package thumbnail_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+func TestProvidersParse(t *testing.T) {
+	providers, err := thumbnail.NewProviders(&config.Config{PeerTube: config.PeerTubeConfig{Instances: []string{"https://framatube.org"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		videoURL string
+		want     string
+		wantErr  error
+	}{
+		{name: "youtube", videoURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{name: "youtube short", videoURL: "youtu.be/dQw4w9WgXcQ", want: "youtube:dQw4w9WgXcQ"},
+		{name: "youtube invalid ID", videoURL: "https://youtu.be/../../x", wantErr: thumbnail.ErrInvalidVideoID},
+		{name: "vimeo", videoURL: "https://vimeo.com/76979871", want: "vimeo:76979871"},
+		{name: "vimeo unlisted", videoURL: "https://vimeo.com/76979871/8272103f6e", want: "vimeo:76979871/8272103f6e"},
+		{name: "vimeo channel", videoURL: "https://vimeo.com/channels/staffpicks/76979871", want: "vimeo:76979871"},
+		{name: "vimeo group", videoURL: "https://vimeo.com/groups/shortfilms/videos/76979871", want: "vimeo:76979871"},
+		{name: "vimeo showcase", videoURL: "https://vimeo.com/showcase/123/video/76979871", want: "vimeo:76979871"},
+		{name: "vimeo player", videoURL: "https://player.vimeo.com/video/76979871?h=abc", want: "vimeo:76979871/abc"},
+		{name: "vimeo invalid hash", videoURL: "https://vimeo.com/76979871/not-a-hash", wantErr: thumbnail.ErrInvalidVideoID},
+		{name: "vimeo unknown path", videoURL: "https://vimeo.com/about", wantErr: thumbnail.ErrInvalidVideoURL},
+		{name: "vimeo invalid ID", videoURL: "https://player.vimeo.com/video/abc", wantErr: thumbnail.ErrInvalidVideoID},
+		{name: "dailymotion", videoURL: "https://www.dailymotion.com/video/x7tgad0", want: "dailymotion:x7tgad0"},
+		{name: "dailymotion with slug", videoURL: "https://www.dailymotion.com/video/x7tgad0_some-title", want: "dailymotion:x7tgad0"},
+		{name: "dailymotion embed", videoURL: "https://www.dailymotion.com/embed/video/x7tgad0", want: "dailymotion:x7tgad0"},
+		{name: "dailymotion player", videoURL: "https://geo.dailymotion.com/player.html?video=x7tgad0", want: "dailymotion:x7tgad0"},
+		{name: "dailymotion short", videoURL: "https://dai.ly/x7tgad0", want: "dailymotion:x7tgad0"},
+		{name: "dailymotion invalid ID", videoURL: "https://dai.ly/x7t.gad0", wantErr: thumbnail.ErrInvalidVideoID},
+		{
+			name:     "peertube",
+			videoURL: "https://framatube.org/w/9c9de5e8-0a1e-484a-b099-e80766180a6d",
+			want:     "peertube:framatube.org/9c9de5e8-0a1e-484a-b099-e80766180a6d",
+		},
+		{name: "peertube watch", videoURL: "https://framatube.org/videos/watch/kkGMgK9ZtnKfYAgnEtQxbv", want: "peertube:framatube.org/kkGMgK9ZtnKfYAgnEtQxbv"},
+		{name: "peertube embed", videoURL: "https://framatube.org/videos/embed/kkGMgK9ZtnKfYAgnEtQxbv", want: "peertube:framatube.org/kkGMgK9ZtnKfYAgnEtQxbv"},
+		{name: "peertube playlist", videoURL: "https://framatube.org/w/p/kkGMgK9ZtnKfYAgnEtQxbv", wantErr: thumbnail.ErrInvalidVideoURL},
+		{name: "peertube unknown instance", videoURL: "https://peertube.example/w/kkGMgK9ZtnKfYAgnEtQxbv", wantErr: thumbnail.ErrInvalidVideoURL},
+		{name: "unknown host", videoURL: "https://example.com/video/1", wantErr: thumbnail.ErrInvalidVideoURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video, err := providers.Parse(tt.videoURL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got := video.Key(); got != tt.want {
+				t.Errorf("Parse() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPeerTubeInvalidInstance(t *testing.T) {
+	for _, instance := range []string{"framatube.org", "ftp://framatube.org", "://"} {
+		if _, err := thumbnail.NewPeerTube([]string{instance}); err == nil {
+			t.Errorf("NewPeerTube(%q) error = nil, want error", instance)
+		}
+	}
+}
+
+// newOEmbedServer starts a fake oEmbed server that knows a single video URL.
+func newOEmbedServer(t *testing.T, videoURL, thumbnailURL string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") != videoURL {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"type":"video","version":"1.0","thumbnail_url":%q}`, thumbnailURL)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVimeoThumbnailURL(t *testing.T) {
+	const thumbnailURL = "https://i.vimeocdn.com/video/452001751-8216e0571c251a09d7a8387550942d89f7f86f6398f8ed886e639b0dd50d3c90-d_640"
+	srv := newOEmbedServer(t, "https://vimeo.com/76979871", thumbnailURL)
+	vimeo := &thumbnail.Vimeo{OEmbedURL: srv.URL + "/api/oembed.json"}
+
+	got, err := vimeo.ThumbnailURL(context.Background(), thumbnail.NewFetcherWithClient(srv.Client(), nil), "76979871")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != thumbnailURL {
+		t.Errorf("ThumbnailURL() got = %v, want %v", got, thumbnailURL)
+	}
+
+	if _, err := vimeo.ThumbnailURL(context.Background(), thumbnail.NewFetcherWithClient(srv.Client(), nil), "1"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("ThumbnailURL() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+}
+
+func TestVimeoThumbnailURLUnlisted(t *testing.T) {
+	const thumbnailURL = "https://i.vimeocdn.com/video/452001751-d_640"
+	srv := newOEmbedServer(t, "https://vimeo.com/76979871/8272103f6e", thumbnailURL)
+	vimeo := &thumbnail.Vimeo{OEmbedURL: srv.URL + "/api/oembed.json"}
+
+	video, err := thumbnail.Providers{vimeo}.Parse("https://vimeo.com/76979871/8272103f6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := vimeo.ThumbnailURL(context.Background(), thumbnail.NewFetcherWithClient(srv.Client(), nil), video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != thumbnailURL {
+		t.Errorf("ThumbnailURL() got = %v, want %v", got, thumbnailURL)
+	}
+}
+
+func TestVimeoThumbnailURLNotAllowed(t *testing.T) {
+	tests := []struct {
+		name         string
+		thumbnailURL string
+	}{
+		{name: "http", thumbnailURL: "http://i.vimeocdn.com/video/452001751-d_640"},
+		{name: "internal host", thumbnailURL: "https://169.254.169.254/latest/meta-data/"},
+		{name: "subdomain", thumbnailURL: "https://i.vimeocdn.com.example.com/video/452001751-d_640"},
+		{name: "port", thumbnailURL: "https://i.vimeocdn.com:8080/video/452001751-d_640"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newOEmbedServer(t, "https://vimeo.com/76979871", tt.thumbnailURL)
+			vimeo := &thumbnail.Vimeo{OEmbedURL: srv.URL + "/api/oembed.json"}
+
+			if got, err := vimeo.ThumbnailURL(context.Background(), thumbnail.NewFetcherWithClient(srv.Client(), nil), "76979871"); err == nil {
+				t.Errorf("ThumbnailURL() = %v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestYouTubeIsPlaceholder(t *testing.T) {
+	placeholder := []byte("placeholder")
+	sum := sha256.Sum256(placeholder)
+	youTube := &thumbnail.YouTube{PlaceholderHashes: []string{hex.EncodeToString(sum[:])}}
+
+	tests := []struct {
+		name string
+		url  string
+		t    *thumbnail.Thumbnail
+		want bool
+	}{
+		{name: "thumbnail", url: "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg", t: &thumbnail.Thumbnail{Data: []byte("thumbnail"), Width: 480, Height: 360}, want: false},
+		{name: "known hash", url: "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg", t: &thumbnail.Thumbnail{Data: placeholder, Width: 480, Height: 360}, want: true},
+		{name: "placeholder size", url: "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg", t: &thumbnail.Thumbnail{Data: []byte("thumbnail"), Width: 120, Height: 90}, want: true},
+		{name: "placeholder size of default", url: "https://i.ytimg.com/vi/dQw4w9WgXcQ/default.jpg", t: &thumbnail.Thumbnail{Data: []byte("thumbnail"), Width: 120, Height: 90}, want: false},
+		{name: "known hash of default", url: "https://i.ytimg.com/vi/dQw4w9WgXcQ/default.jpg", t: &thumbnail.Thumbnail{Data: placeholder, Width: 120, Height: 90}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := youTube.IsPlaceholder(tt.url, tt.t); got != tt.want {
+				t.Errorf("IsPlaceholder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDailymotionThumbnailURL(t *testing.T) {
+	dailymotion := &thumbnail.Dailymotion{BaseURL: "http://127.0.0.1:8080/"}
+
+	got, err := dailymotion.ThumbnailURL(context.Background(), nil, "x7tgad0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://127.0.0.1:8080/thumbnail/video/x7tgad0"; got != want {
+		t.Errorf("ThumbnailURL() got = %v, want %v", got, want)
+	}
+}
+
+func TestPeerTubeThumbnailURL(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/oembed" || r.URL.Query().Get("url") != srv.URL+"/w/kkGMgK9ZtnKfYAgnEtQxbv" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"thumbnail_url":%q}`, srv.URL+"/lazy-static/thumbnails/1.jpg")
+	}))
+	t.Cleanup(srv.Close)
+
+	peerTube, err := thumbnail.NewPeerTube([]string{srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := thumbnail.Providers{peerTube}.Parse(srv.URL + "/w/kkGMgK9ZtnKfYAgnEtQxbv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := video.Provider.ThumbnailURL(context.Background(), thumbnail.NewFetcherWithClient(srv.Client(), nil), video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/lazy-static/thumbnails/1.jpg"; got != want {
+		t.Errorf("ThumbnailURL() got = %v, want %v", got, want)
+	}
+}
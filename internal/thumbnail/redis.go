@@ -0,0 +1,228 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+)
+
+var (
+	// redisHits and redisMisses expose the number of lookups served by the
+	// Redis caches and the number of lookups they missed at /debug/vars.
+	redisHits   = expvar.NewInt("cache_redis_hits")
+	redisMisses = expvar.NewInt("cache_redis_misses")
+)
+
+// redisScanCount is the number of keys ClearNotFound asks Redis to scan at
+// once.
+const redisScanCount = 1000
+
+// redisExtendScript extends a thumbnail that exists. It returns 1 if it was
+// extended and 0 if it doesn't exist.
+var redisExtendScript = redis.NewScript(`
+	if redis.call('EXISTS', KEYS[1]) == 0 then
+		return 0
+	end
+	redis.call('HSET', KEYS[1], 'expires_at', ARGV[1], 'etag', ARGV[2], 'last_modified', ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[4])
+	return 1
+`)
+
+// RedisCache is a cache for thumbnail images stored in Redis, which several
+// server replicas may share.
+//
+// A thumbnail is a hash of its metadata and data, so a hit is served by a
+// single command. Entries expire natively: a thumbnail is kept for Retention
+// after its expiration and a video is cached as not found until its
+// expiration. Evictions are up to the maxmemory policy of the server.
+type RedisCache struct {
+	// Retention is the time thumbnails are kept after their expiration, e.g.
+	// to be served stale.
+	Retention time.Duration
+
+	client *redis.Client
+	prefix string
+}
+
+// OpenRedisCache opens a new Redis cache with the connection settings of a
+// given config and checks that the server is reachable.
+func OpenRedisCache(cfg *config.RedisConfig) (*RedisCache, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	opts.PoolSize = cfg.PoolSize
+	opts.DialTimeout = cfg.DialTimeout
+	opts.ReadTimeout = cfg.ReadTimeout
+	opts.WriteTimeout = cfg.WriteTimeout
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, errors.Join(err, client.Close())
+	}
+	return &RedisCache{client: client, prefix: cfg.KeyPrefix}, nil
+}
+
+// Close implements Cache.
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// GetThumbnail implements Cache.
+func (c *RedisCache) GetThumbnail(videoID string) (*Thumbnail, error) {
+	fields, err := c.client.HGetAll(context.Background(), c.thumbnailKey(videoID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		redisMisses.Add(1)
+		return nil, ErrNotFound
+	}
+	redisHits.Add(1)
+
+	expiration, err := strconv.ParseInt(fields["expires_at"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiration of thumbnail %s: %w", videoID, err)
+	}
+	width, err := strconv.Atoi(fields["width"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid width of thumbnail %s: %w", videoID, err)
+	}
+	height, err := strconv.Atoi(fields["height"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid height of thumbnail %s: %w", videoID, err)
+	}
+
+	t := &Thumbnail{
+		ContentType:  fields["content_type"],
+		Data:         []byte(fields["data"]),
+		Expiration:   time.Unix(expiration, 0),
+		Width:        width,
+		Height:       height,
+		ETag:         fields["etag"],
+		LastModified: fields["last_modified"],
+	}
+	return t, nil
+}
+
+// SetThumbnail implements Cache. A thumbnail past its retention isn't stored
+// and replaces nothing but its previous version.
+func (c *RedisCache) SetThumbnail(videoID string, t *Thumbnail) error {
+	ctx := context.Background()
+	key := c.thumbnailKey(videoID)
+
+	ttl := time.Until(t.Expiration) + c.Retention
+	if ttl < time.Millisecond {
+		return c.client.Del(ctx, key).Err()
+	}
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"content_type", t.ContentType,
+			"data", t.Data,
+			"expires_at", t.Expiration.Unix(),
+			"width", t.Width,
+			"height", t.Height,
+			"etag", t.ETag,
+			"last_modified", t.LastModified,
+		)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// ExtendThumbnail implements Cache. A thumbnail extended to past its retention
+// is deleted.
+func (c *RedisCache) ExtendThumbnail(videoID string, t *Thumbnail) error {
+	ttl := time.Until(t.Expiration) + c.Retention
+	keys := []string{c.thumbnailKey(videoID)}
+	args := []any{t.Expiration.Unix(), t.ETag, t.LastModified, max(ttl.Milliseconds(), 0)}
+
+	extended, err := redisExtendScript.Run(context.Background(), c.client, keys, args...).Int()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IsNotFound implements Cache.
+func (c *RedisCache) IsNotFound(videoID string) (bool, error) {
+	n, err := c.client.Exists(context.Background(), c.notFoundKey(videoID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SetNotFound implements Cache.
+func (c *RedisCache) SetNotFound(videoID string, expiration time.Time) error {
+	ctx := context.Background()
+	key := c.notFoundKey(videoID)
+
+	ttl := time.Until(expiration)
+	if ttl < time.Millisecond {
+		return c.client.Del(ctx, key).Err()
+	}
+	return c.client.Set(ctx, key, 1, ttl).Err()
+}
+
+// ClearNotFound implements Cache.
+func (c *RedisCache) ClearNotFound() (int64, error) {
+	ctx := context.Background()
+	match := escapeRedisPattern(c.prefix) + "not_found:*"
+
+	var n int64
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, match, redisScanCount).Result()
+		if err != nil {
+			return n, err
+		}
+		if len(keys) > 0 {
+			deleted, err := c.client.Del(ctx, keys...).Result()
+			n += deleted
+			if err != nil {
+				return n, err
+			}
+		}
+		if next == 0 {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+// thumbnailKey returns the key of the thumbnail of a video.
+func (c *RedisCache) thumbnailKey(videoID string) string {
+	return c.prefix + "thumbnail:" + videoID
+}
+
+// notFoundKey returns the key that marks a video as not found.
+func (c *RedisCache) notFoundKey(videoID string) string {
+	return c.prefix + "not_found:" + videoID
+}
+
+// escapeRedisPattern escapes the special characters of Redis glob-style
+// patterns in s.
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+)
+
+// openRedisCache opens a Redis cache of an in-process Redis server.
+func openRedisCache(t *testing.T) (*thumbnail.RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cache, err := thumbnail.OpenRedisCache(&config.RedisConfig{
+		URL:          "redis://" + server.Addr(),
+		KeyPrefix:    "youthumb:",
+		DialTimeout:  time.Second,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache, server
+}
+
+func TestRedisCache(t *testing.T) {
+	cache, server := openRedisCache(t)
+	cache.Retention = time.Minute
+
+	expiration := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	want := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: expiration, Width: 160, Height: 90, ETag: `"v1"`, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"}
+	if err := cache.SetThumbnail("a", want); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hit is a single round trip.
+	commands := server.CommandCount()
+	got, err := cache.GetThumbnail("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := server.CommandCount() - commands; n != 1 {
+		t.Errorf("GetThumbnail() sent %d commands, want 1", n)
+	}
+	if !bytes.Equal(got.Data, want.Data) || !got.Expiration.Equal(want.Expiration) || got.ContentType != want.ContentType ||
+		got.Width != want.Width || got.Height != want.Height || got.ETag != want.ETag || got.LastModified != want.LastModified {
+		t.Errorf("GetThumbnail() = %+v, want %+v", got, want)
+	}
+
+	// Thumbnails expire natively after their retention.
+	if ttl := server.TTL("youthumb:thumbnail:a"); ttl <= time.Hour || ttl > time.Hour+time.Minute {
+		t.Errorf("TTL = %s, want the time until the expiration plus the retention", ttl)
+	}
+	extended := &thumbnail.Thumbnail{Expiration: expiration.Add(time.Hour), ETag: `"v2"`}
+	if err := cache.ExtendThumbnail("a", extended); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.GetThumbnail("a"); err != nil || !got.Expiration.Equal(extended.Expiration) || got.ETag != extended.ETag || !bytes.Equal(got.Data, want.Data) {
+		t.Errorf("GetThumbnail() = %+v, %v, want the extended thumbnail", got, err)
+	}
+	server.FastForward(2*time.Hour + 2*time.Minute)
+	if _, err := cache.GetThumbnail("a"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+	if err := cache.ExtendThumbnail("a", extended); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("ExtendThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+
+	// A thumbnail past its retention isn't stored.
+	if err := cache.SetThumbnail("b", &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetThumbnail("b"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want %v", err, thumbnail.ErrNotFound)
+	}
+
+	for videoID, expiration := range map[string]time.Time{"c": time.Now().Add(time.Hour), "d": time.Now().Add(2 * time.Hour), "e": time.Now().Add(-time.Second)} {
+		if err := cache.SetNotFound(videoID, expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for videoID, want := range map[string]bool{"a": false, "c": true, "d": true, "e": false} {
+		if got, err := cache.IsNotFound(videoID); err != nil || got != want {
+			t.Errorf("IsNotFound(%q) = %v, %v, want %v", videoID, got, err, want)
+		}
+	}
+	server.FastForward(90 * time.Minute)
+	if got, err := cache.IsNotFound("c"); err != nil || got {
+		t.Errorf("IsNotFound(%q) = %v, %v, want expired", "c", got, err)
+	}
+	if n, err := cache.ClearNotFound(); err != nil || n != 1 {
+		t.Errorf("ClearNotFound() = %d, %v, want 1", n, err)
+	}
+}
+
+func TestRedisCacheTier(t *testing.T) {
+	redisCache, _ := openRedisCache(t)
+	sqliteCache, err := thumbnail.OpenSQLiteCache(filepath.Join(t.TempDir(), "cache.db"), &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqliteCache.Close() })
+	memoryCache := thumbnail.NewMemoryCache(1 << 20)
+	cache := thumbnail.NewTieredCache(memoryCache, thumbnail.NewTieredCache(redisCache, sqliteCache))
+
+	want := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("jpeg"), Expiration: time.Now().Add(time.Hour)}
+	if err := cache.SetThumbnail("a", want); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]thumbnail.Cache{"memory": memoryCache, "redis": redisCache, "sqlite": sqliteCache} {
+		if _, err := c.GetThumbnail("a"); err != nil {
+			t.Errorf("%s GetThumbnail() error = %v", name, err)
+		}
+	}
+
+	// Another replica shares the Redis tier, a thumbnail only in the database
+	// is copied to it.
+	if err := sqliteCache.SetThumbnail("b", want); err != nil {
+		t.Fatal(err)
+	}
+	replica := thumbnail.NewTieredCache(thumbnail.NewMemoryCache(1<<20), thumbnail.NewTieredCache(redisCache, sqliteCache))
+	if _, err := replica.GetThumbnail("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redisCache.GetThumbnail("b"); err != nil {
+		t.Errorf("redis GetThumbnail() error = %v, want the thumbnail copied to Redis", err)
+	}
+	if _, err := cache.GetThumbnail("b"); err != nil {
+		t.Errorf("GetThumbnail() error = %v", err)
+	}
+}
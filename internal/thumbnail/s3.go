@@ -0,0 +1,188 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+)
+
+// S3BlobStore is a BlobStore in a bucket of an S3-compatible object storage,
+// e.g. Amazon S3 or MinIO, which several server replicas may share. A blob is
+// stored at "prefix/ab/cd/abcd..." by its hash with the content type and the
+// expiration of the thumbnail that wrote it last.
+//
+// An object is written by a single request, so a blob is either complete or
+// absent.
+type S3BlobStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	// timeout is the max time of a write, zero means no limit.
+	timeout time.Duration
+}
+
+// OpenS3BlobStore opens a new blob store with the connection settings of a
+// given config and checks that the bucket exists.
+func OpenS3BlobStore(cfg *config.S3Config) (*S3BlobStore, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure: u.Scheme == "https",
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", cfg.Bucket)
+	}
+	return &S3BlobStore{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix, timeout: cfg.Timeout}, nil
+}
+
+// Put implements BlobStore. If the object already exists, it's copied onto
+// itself with the metadata of the thumbnail rather than uploaded again, which
+// refreshes its modification time.
+func (s *S3BlobStore) Put(t *Thumbnail) (string, error) {
+	ctx, cancel := s.writeContext()
+	defer cancel()
+
+	hash := blobHash(t.Data)
+	key := s.key(hash)
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		opts := minio.PutObjectOptions{ContentType: t.ContentType, Expires: t.Expiration}
+		if _, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(t.Data), int64(len(t.Data)), opts); err != nil {
+			return "", err
+		}
+		return hash, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	metadata := map[string]string{"Content-Type": t.ContentType}
+	if !t.Expiration.IsZero() {
+		metadata["Expires"] = t.Expiration.UTC().Format(http.TimeFormat)
+	}
+	dst := minio.CopyDestOptions{Bucket: s.bucket, Object: key, UserMetadata: metadata, ReplaceMetadata: true}
+	if _, err := s.client.CopyObject(ctx, dst, minio.CopySrcOptions{Bucket: s.bucket, Object: key}); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// writeContext returns the context of a write, which is done once the timeout
+// elapses.
+func (s *S3BlobStore) writeContext() (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.Background(), func() {}
+	}
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+// Open implements BlobStore. The returned reader is the body of a single GET
+// request, so a missing blob is reported by Open rather than by the first read.
+func (s *S3BlobStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if !isBlobHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash: %q", hash)
+	}
+	core := minio.Core{Client: s.client}
+	body, _, _, err := core.GetObject(ctx, s.bucket, s.key(hash), minio.GetObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Collect implements BlobStore. Objects under the prefix that aren't named as
+// blobs are kept. The modification time of a blob is checked again right
+// before it's deleted, since a blob may be written again while the bucket is
+// listed.
+func (s *S3BlobStore) Collect(ctx context.Context, before time.Time, referenced func(hash string) bool) (int64, int64, error) {
+	var victims []minio.ObjectInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if object.Err != nil {
+			return 0, 0, object.Err
+		}
+		hash := path.Base(object.Key)
+		if !isBlobHash(hash) || object.Key != s.key(hash) || !object.LastModified.Before(before) || referenced(hash) {
+			continue
+		}
+		victims = append(victims, object)
+	}
+	victims, err := s.unmodified(ctx, victims, before)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(victims) == 0 {
+		return 0, 0, nil
+	}
+
+	objects := make(chan minio.ObjectInfo, len(victims))
+	for _, object := range victims {
+		objects <- object
+	}
+	close(objects)
+	failed := make(map[string]bool)
+	var errs []error
+	for removeErr := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		failed[removeErr.ObjectName] = true
+		errs = append(errs, fmt.Errorf("failed to remove %s: %w", removeErr.ObjectName, removeErr.Err))
+	}
+
+	var n, size int64
+	for _, object := range victims {
+		if !failed[object.Key] {
+			n++
+			size += object.Size
+		}
+	}
+	return n, size, errors.Join(errs...)
+}
+
+// unmodified returns the objects that still exist and were last modified
+// before a given time.
+func (s *S3BlobStore) unmodified(ctx context.Context, objects []minio.ObjectInfo, before time.Time) ([]minio.ObjectInfo, error) {
+	var unmodified []minio.ObjectInfo
+	for _, object := range objects {
+		info, err := s.client.StatObject(ctx, s.bucket, object.Key, minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.LastModified.Before(before) {
+			unmodified = append(unmodified, info)
+		}
+	}
+	return unmodified, nil
+}
+
+// key returns the object key of a blob.
+func (s *S3BlobStore) key(hash string) string {
+	return s.prefix + hash[0:2] + "/" + hash[2:4] + "/" + hash
+}
@@ -1,13 +1,21 @@
 package thumbnail
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kirillgashkov/assignment-youthumb/internal/rpc/message"
 	"github.com/kirillgashkov/assignment-youthumb/proto/youthumbpb/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 const (
@@ -15,21 +23,68 @@ const (
 	maxChunkSize = 64 * 1024
 )
 
+// errSend is returned when a chunk can't be sent to the client.
+var errSend = errors.New("failed to send chunk")
+
 var (
 	ErrStatusMissingVideoURL = status.Errorf(codes.InvalidArgument, "video URL is required")
 	ErrStatusInvalidVideoURL = status.Errorf(codes.InvalidArgument, "video URL is invalid")
 	ErrStatusNotFound        = status.Errorf(codes.NotFound, "video or thumbnail not found")
+	ErrStatusRateLimited     = status.Errorf(codes.ResourceExhausted, "upstream rate limit exceeded")
+
+	ErrStatusMissingPlaylistURL  = status.Errorf(codes.InvalidArgument, "playlist URL is required")
+	ErrStatusInvalidPlaylistURL  = status.Errorf(codes.InvalidArgument, "playlist URL is invalid")
+	ErrStatusPlaylistNotFound    = status.Errorf(codes.NotFound, "playlist or channel not found")
+	ErrStatusUnsupportedPlaylist = status.Errorf(codes.Unimplemented, "playlist or channel is not supported")
 )
 
 // Service is a thumbnail service.
 type Service struct {
 	youthumbpb.UnimplementedThumbnailServiceServer
-	cache *Cache
+
+	// Freshness is the policy of deriving the expiration of downloaded
+	// thumbnails. Thumbnails that expire immediately are not cached.
+	Freshness FreshnessPolicy
+	// MaxThumbnailSize is the max size of a downloaded thumbnail in bytes,
+	// zero means no limit.
+	MaxThumbnailSize int64
+	// StaleWhileRevalidate is the time after the expiration during which an
+	// expired thumbnail is served while it's refreshed in the background.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is the time after the expiration during which an expired
+	// thumbnail is served if it can't be downloaded again.
+	StaleIfError time.Duration
+	// NotFoundTTL is the time a video that upstream doesn't have is cached as
+	// not found, zero disables negative caching.
+	NotFoundTTL time.Duration
+	// DownloadTimeout is the max time of a download shared by concurrent
+	// requests, including the waits for the rate limits, the retries and the
+	// transfer of the body, zero means no limit.
+	DownloadTimeout time.Duration
+
+	cache            Cache
+	providers        Providers
+	playlistResolver PlaylistResolver
+	fetcher          *Fetcher
+	flights          flightGroup[result]
+	// transfers are the thumbnails being downloaded by video key, they are
+	// removed once they're complete and cached.
+	transfers sync.Map
+	// rateLimited are the flags of the downloads in flight by video key that
+	// are set while the downloads wait for the rate limits.
+	rateLimited sync.Map
 }
 
-// NewService creates a new thumbnail service.
-func NewService(cache *Cache) *Service {
-	return &Service{cache: cache}
+// NewService creates a new thumbnail service for videos of the given providers.
+// Playlists and channels are expanded into videos by the given resolver.
+// Upstream requests are sent by the given fetcher.
+func NewService(cache Cache, providers Providers, playlistResolver PlaylistResolver, fetcher *Fetcher) *Service {
+	return &Service{
+		cache:            cache,
+		providers:        providers,
+		playlistResolver: playlistResolver,
+		fetcher:          fetcher,
+	}
 }
 
 // GetThumbnail returns a thumbnail for a given video URL.
@@ -41,96 +96,389 @@ func (s *Service) GetThumbnail(
 		return ErrStatusMissingVideoURL
 	}
 
-	videoID, err := ParseVideoID(req.VideoUrl)
+	err := s.sendByVideoURL(stream.Context(), req.VideoUrl, stream.Send)
+	if errors.Is(err, errSend) {
+		slog.Error("failed to send thumbnail", "error", err)
+		return message.ErrStatusInternal
+	}
+	return err
+}
+
+// GetPlaylistThumbnails returns thumbnails for the videos of a given playlist or
+// channel URL. A failure to get the thumbnail of a single video is reported in
+// the stream and doesn't fail the whole RPC.
+func (s *Service) GetPlaylistThumbnails(
+	req *youthumbpb.GetPlaylistThumbnailsRequest,
+	stream youthumbpb.ThumbnailService_GetPlaylistThumbnailsServer,
+) error {
+	if req.PlaylistUrl == "" {
+		return ErrStatusMissingPlaylistURL
+	}
+
+	playlist, err := ParsePlaylist(req.PlaylistUrl)
 	if err != nil {
-		return ErrStatusInvalidVideoURL
+		return ErrStatusInvalidPlaylistURL
 	}
 
-	t, err := s.getByVideoID(videoID)
+	videoURLs, err := s.playlistResolver.Resolve(stream.Context(), s.fetcher, playlist)
+	var circuitOpenErr *CircuitOpenError
 	if errors.Is(err, ErrNotFound) {
-		return ErrStatusNotFound
+		return ErrStatusPlaylistNotFound
+	} else if errors.As(err, &circuitOpenErr) {
+		return unavailableStatus(circuitOpenErr)
+	} else if errors.Is(err, ErrRateLimited) {
+		return ErrStatusRateLimited
+	} else if errors.Is(err, ErrUnsupportedPlaylist) {
+		return ErrStatusUnsupportedPlaylist
 	} else if err != nil {
-		slog.Error("failed to get thumbnail", "error", err)
+		slog.Error("failed to resolve playlist", "playlist_url", req.PlaylistUrl, "error", err)
 		return message.ErrStatusInternal
 	}
 
-	if err := send(stream, t); err != nil {
-		slog.Error("failed to send thumbnail", "error", err)
-		return message.ErrStatusInternal
+	for _, videoURL := range videoURLs {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		err := s.sendByVideoURL(stream.Context(), videoURL, func(chunk *youthumbpb.ThumbnailChunk) error {
+			return stream.Send(&youthumbpb.PlaylistThumbnailChunk{VideoUrl: videoURL, Chunk: chunk})
+		})
+		if errors.Is(err, errSend) {
+			slog.Error("failed to send thumbnail", "error", err)
+			return message.ErrStatusInternal
+		}
+		if err != nil {
+			st := status.Convert(err)
+			chunk := &youthumbpb.PlaylistThumbnailChunk{
+				VideoUrl:     videoURL,
+				ErrorCode:    int32(st.Code()),
+				ErrorMessage: st.Message(),
+			}
+			if err := stream.Send(chunk); err != nil {
+				slog.Error("failed to send thumbnail error", "error", err)
+				return message.ErrStatusInternal
+			}
+		}
 	}
 
 	return nil
 }
 
-// getByVideoID returns a thumbnail for a given video URL.
-func (s *Service) getByVideoID(videoID string) (*Thumbnail, error) {
-	t, err := s.cache.GetThumbnail(videoID)
+// result is a thumbnail with the cache status of the response.
+type result struct {
+	tr *transfer
+	// body is the data of a thumbnail streamed from the cache, it's read
+	// instead of the data of tr. Such results are never shared.
+	body   io.ReadCloser
+	status youthumbpb.CacheStatus
+}
+
+// read returns the chunk of data at a given offset, see transfer.read.
+func (r result) read(ctx context.Context, offset int) ([]byte, error) {
+	if r.body == nil {
+		return r.tr.read(ctx, offset, maxChunkSize)
+	}
 
-	// Cache hit.
+	// The body is read in order, so the offset is always where it's at.
+	data := make([]byte, maxChunkSize)
+	n, err := io.ReadFull(r.body, data)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// close closes the body of a result streamed from the cache.
+func (r result) close() {
+	if r.body == nil {
+		return
+	}
+	if err := r.body.Close(); err != nil {
+		slog.Error("failed to close cached thumbnail", "error", err)
+	}
+}
+
+// sendByVideoURL sends a thumbnail for a given video URL in chunks. Chunks of a
+// thumbnail that is still downloading are sent as they arrive, so the
+// thumbnail may fail after some of its chunks are sent.
+// Errors of sending chunks wrap errSend, other returned errors are gRPC status
+// errors.
+func (s *Service) sendByVideoURL(ctx context.Context, videoURL string, sendChunk func(*youthumbpb.ThumbnailChunk) error) error {
+	video, err := s.providers.Parse(videoURL)
+	if err != nil {
+		return ErrStatusInvalidVideoURL
+	}
+
+	r, err := s.getByVideoID(ctx, video)
 	if err == nil {
-		return t, nil
+		err = send(ctx, sendChunk, r)
+		r.close()
+	}
+	if err == nil || errors.Is(err, errSend) {
+		return err
 	}
 
+	var circuitOpenErr *CircuitOpenError
+	if errors.Is(err, ErrNotFound) {
+		return ErrStatusNotFound
+	} else if errors.As(err, &circuitOpenErr) {
+		return unavailableStatus(circuitOpenErr)
+	} else if errors.Is(err, ErrRateLimited) {
+		return ErrStatusRateLimited
+	} else if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	slog.Error("failed to get thumbnail", "video", video.Key(), "error", err)
+	return message.ErrStatusInternal
+}
+
+// getByVideoID returns a thumbnail for a given video.
+// Thumbnails are cached by the namespaced video key. Concurrent cache misses of
+// the same video are coalesced into one download. Within the
+// stale-while-revalidate window an expired thumbnail is returned as is and
+// refreshed in the background. Hits of caches that stream thumbnail data are
+// streamed, see ThumbnailOpener.
+func (s *Service) getByVideoID(ctx context.Context, video Video) (result, error) {
+	t, body, err := s.openThumbnail(ctx, video.Key())
+
 	// Error other than cache miss.
-	if !errors.Is(err, ErrNotFound) {
-		return nil, err
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return result{}, err
+	}
+
+	if err == nil {
+		now := time.Now()
+
+		// Cache hit.
+		if t.Expiration.After(now) {
+			return result{tr: completeTransfer(t), body: body, status: youthumbpb.CacheStatus_CACHE_STATUS_HIT}, nil
+		}
+
+		// Stale hit.
+		if now.Before(t.Expiration.Add(s.StaleWhileRevalidate)) {
+			go s.refresh(context.WithoutCancel(ctx), video)
+			return result{tr: completeTransfer(t), body: body, status: youthumbpb.CacheStatus_CACHE_STATUS_STALE}, nil
+		}
+		result{body: body}.close()
+	}
+
+	// Negative cache hit.
+	if s.NotFoundTTL > 0 {
+		notFound, err := s.cache.IsNotFound(video.Key())
+		if err != nil {
+			return result{}, err
+		}
+		if notFound {
+			return result{}, ErrNotFound
+		}
 	}
 
 	// Cache miss.
-	thumbnailURL, err := URL(videoID)
-	if err != nil {
-		return nil, err
+	r, err := s.flights.do(ctx, video.Key(), s.DownloadTimeout, func(ctx context.Context) (result, error) {
+		return s.download(ctx, video)
+	})
+	// The request gives up on a download that still waits for the rate
+	// limits.
+	if flag, ok := s.rateLimited.Load(video.Key()); ok && ctx.Err() != nil && flag.(*atomic.Bool).Load() {
+		return result{}, fmt.Errorf("%w: %w", ErrRateLimited, err)
+	}
+	return r, err
+}
+
+// download starts downloading a thumbnail for a given video, see
+// getMissByVideoID. While the download waits for the rate limits, it's
+// flagged in rateLimited.
+func (s *Service) download(ctx context.Context, video Video) (result, error) {
+	ctx, flag := withRateLimitedFlag(ctx)
+	s.rateLimited.Store(video.Key(), flag)
+	defer s.rateLimited.Delete(video.Key())
+
+	return s.getMissByVideoID(ctx, video)
+}
+
+// openThumbnail returns a thumbnail from the cache and a reader of its data if
+// the cache streams it, see ThumbnailOpener.
+func (s *Service) openThumbnail(ctx context.Context, videoID string) (*Thumbnail, io.ReadCloser, error) {
+	if o, ok := s.cache.(ThumbnailOpener); ok {
+		return o.OpenThumbnail(ctx, videoID)
 	}
+	t, err := s.cache.GetThumbnail(videoID)
+	return t, nil, err
+}
 
-	downloadedThumbnail, err := download(thumbnailURL)
+// refresh refreshes an expired thumbnail of a given video in the cache.
+func (s *Service) refresh(ctx context.Context, video Video) {
+	_, err := s.flights.do(ctx, video.Key(), s.DownloadTimeout, func(ctx context.Context) (result, error) {
+		return s.download(ctx, video)
+	})
 	if err != nil {
-		return nil, err
+		slog.Error("failed to refresh stale thumbnail", "video", video.Key(), "error", err)
 	}
+}
 
-	if err := s.cache.SetThumbnail(videoID, downloadedThumbnail); err != nil {
-		slog.Error("failed to set thumbnail in cache", "error", err)
+// getMissByVideoID starts downloading a thumbnail for a given video that
+// missed the cache. The download joins a transfer of the same video that is
+// already in flight if any. The thumbnail is cached once it's downloaded in
+// full, see complete. Expired thumbnails are revalidated instead of downloaded
+// in full if possible. Within the stale-if-error window an expired thumbnail is
+// returned if the download fails to start.
+func (s *Service) getMissByVideoID(ctx context.Context, video Video) (result, error) {
+	// Transfers are removed only after they're cached, so the cache is checked
+	// after the transfers to not miss both.
+	if tr, ok := s.transfers.Load(video.Key()); ok {
+		return result{tr: tr.(*transfer), status: youthumbpb.CacheStatus_CACHE_STATUS_MISS}, nil
 	}
 
-	return downloadedThumbnail, nil
-}
+	stale, err := s.cache.GetThumbnail(video.Key())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		slog.Error("failed to get stale thumbnail from cache", "error", err)
+	}
 
-// send sends the thumbnail data to the client in chunks.
-func send(stream youthumbpb.ThumbnailService_GetThumbnailServer, t *Thumbnail) error {
-	contentTypeSent := false
-	contentType := t.ContentType
+	// The previous flight might have cached the thumbnail after the miss.
+	if err == nil && stale.Expiration.After(time.Now()) {
+		return result{tr: completeTransfer(stale), status: youthumbpb.CacheStatus_CACHE_STATUS_HIT}, nil
+	}
 
-	for i := 0; i < len(t.Data); i += maxChunkSize {
-		end := i + maxChunkSize
-		if end > len(t.Data) {
-			end = len(t.Data)
+	tr, revalidated, err := s.fetch(ctx, video, stale)
+	if errors.Is(err, ErrNotFound) {
+		s.setNotFound(video)
+	}
+	if err != nil {
+		if stale != nil && s.canServeStale(stale, err) {
+			slog.Warn("serving stale thumbnail", "video", video.Key(), "error", err)
+			return result{tr: completeTransfer(stale), status: youthumbpb.CacheStatus_CACHE_STATUS_STALE}, nil
 		}
+		return result{}, err
+	}
+	slog.Debug("fetched thumbnail", "video", video.Key(), "revalidated", revalidated)
 
-		chunkData := t.Data[i:end]
-		var thumbnailChunk *youthumbpb.ThumbnailChunk
+	if !revalidated {
+		s.transfers.Store(video.Key(), tr)
+		go s.complete(video, tr)
+		return result{tr: tr, status: youthumbpb.CacheStatus_CACHE_STATUS_MISS}, nil
+	}
 
-		// Include ContentType in the first chunk only.
-		if !contentTypeSent {
-			thumbnailChunk = &youthumbpb.ThumbnailChunk{
-				Data:        chunkData,
-				ContentType: contentType,
-			}
-			contentTypeSent = true
-		} else {
-			thumbnailChunk = &youthumbpb.ThumbnailChunk{Data: chunkData}
+	if tr.t.Expiration.After(time.Now()) {
+		t := tr.t
+		if err := s.cache.ExtendThumbnail(video.Key(), &t); err != nil {
+			slog.Error("failed to set thumbnail in cache", "error", err)
 		}
+	}
+	return result{tr: tr, status: youthumbpb.CacheStatus_CACHE_STATUS_REVALIDATED}, nil
+}
 
-		// Send the chunk to the stream.
-		if err := stream.Send(thumbnailChunk); err != nil {
-			return err
+// complete downloads the rest of a thumbnail of a given video and caches it.
+// A thumbnail that fails to download or turns out to be a placeholder is not
+// cached.
+func (s *Service) complete(video Video, tr *transfer) {
+	defer s.transfers.CompareAndDelete(video.Key(), tr)
+
+	err := tr.copy(func(t *Thumbnail) error {
+		if d, ok := video.Provider.(PlaceholderDetector); ok && d.IsPlaceholder(tr.url, t) {
+			placeholders.Add(1)
+			s.setNotFound(video)
+			return fmt.Errorf("%w: placeholder image", ErrNotFound)
+		}
+
+		if !t.Expiration.After(time.Now()) {
+			return nil
 		}
+		if err := s.cache.SetThumbnail(video.Key(), t); err != nil {
+			slog.Error("failed to set thumbnail in cache", "error", err)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("failed to download thumbnail", "video", video.Key(), "error", err)
+	}
+}
+
+// setNotFound caches a given video as not found unless negative caching is
+// disabled.
+func (s *Service) setNotFound(video Video) {
+	if s.NotFoundTTL <= 0 {
+		return
+	}
+	if err := s.cache.SetNotFound(video.Key(), time.Now().Add(s.NotFoundTTL)); err != nil {
+		slog.Error("failed to set not found video in cache", "error", err)
+	}
+}
+
+// canServeStale reports whether an expired thumbnail may be served instead of
+// failing with a given download error. While the circuit breaker of upstream
+// is open, expired thumbnails are served regardless of their age.
+func (s *Service) canServeStale(stale *Thumbnail, err error) bool {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return false
+	case errors.Is(err, ErrCircuitOpen):
+		return true
 	}
+	return time.Now().Before(stale.Expiration.Add(s.StaleIfError))
+}
 
-	if !contentTypeSent {
-		// Send an empty chunk with ContentType if the thumbnail is empty.
-		if err := stream.Send(&youthumbpb.ThumbnailChunk{ContentType: contentType}); err != nil {
+// fetch starts downloading a thumbnail for a given video from its provider. If
+// a stale copy is given, it's revalidated, see download. Placeholders detected
+// by their image header are rejected before the transfer is returned.
+func (s *Service) fetch(ctx context.Context, video Video, stale *Thumbnail) (*transfer, bool, error) {
+	thumbnailURL, err := video.Provider.ThumbnailURL(ctx, s.fetcher, video.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	tr, revalidated, err := download(ctx, s.fetcher, thumbnailURL, stale, s.Freshness, s.MaxThumbnailSize)
+	if err != nil {
+		return nil, false, err
+	}
+	if d, ok := video.Provider.(PlaceholderDetector); ok && !revalidated && d.IsPlaceholder(thumbnailURL, &tr.t) {
+		tr.abort()
+		placeholders.Add(1)
+		return nil, false, fmt.Errorf("%w: placeholder image", ErrNotFound)
+	}
+	return tr, revalidated, nil
+}
+
+// unavailableStatus returns a gRPC status error telling the client to retry
+// after the circuit breaker lets requests through again.
+func unavailableStatus(err *CircuitOpenError) error {
+	st := status.New(codes.Unavailable, "upstream server is unavailable")
+	st, detailsErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(err.RetryAfter)})
+	if detailsErr != nil {
+		slog.Error("failed to add retry info", "error", detailsErr)
+		return status.Error(codes.Unavailable, "upstream server is unavailable")
+	}
+	return st.Err()
+}
+
+// send sends the thumbnail data to the client in chunks as the data becomes
+// available. Errors of sending chunks wrap errSend, other errors are errors of
+// the transfer.
+func send(ctx context.Context, sendChunk func(*youthumbpb.ThumbnailChunk) error, r result) error {
+	// Include ContentType and CacheStatus in the first chunk only.
+	first := true
+	for offset := 0; ; {
+		data, err := r.read(ctx, offset)
+		eof := errors.Is(err, io.EOF)
+		if eof && !first {
+			return nil
+		}
+		// An empty thumbnail is still sent as a single chunk without data, so
+		// that the client gets its content type and cache status.
+		if err != nil && !eof {
 			return err
 		}
-	}
+		offset += len(data)
 
-	return nil
+		thumbnailChunk := &youthumbpb.ThumbnailChunk{Data: data}
+		if first {
+			thumbnailChunk.ContentType = r.tr.t.ContentType
+			thumbnailChunk.CacheStatus = r.status
+			first = false
+		}
+		if err := sendChunk(thumbnailChunk); err != nil {
+			return fmt.Errorf("%w: %w", errSend, err)
+		}
+		if eof {
+			return nil
+		}
+	}
 }
@@ -0,0 +1,880 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
+	"github.com/kirillgashkov/assignment-youthumb/proto/youthumbpb/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newJPEG returns a JPEG image of the given dimensions.
+func newJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type thumbnailStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	chunks []*youthumbpb.ThumbnailChunk
+	// sent, if not nil, receives a value after every sent chunk.
+	sent chan struct{}
+}
+
+func (s *thumbnailStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *thumbnailStream) Send(chunk *youthumbpb.ThumbnailChunk) error {
+	s.chunks = append(s.chunks, chunk)
+	if s.sent != nil {
+		s.sent <- struct{}{}
+	}
+	return nil
+}
+
+// data returns the data of all received chunks.
+func (s *thumbnailStream) data() []byte {
+	var data []byte
+	for _, chunk := range s.chunks {
+		data = append(data, chunk.Data...)
+	}
+	return data
+}
+
+// testServiceOptions are the options of a service created by newTestService.
+type testServiceOptions struct {
+	file      bool
+	hot       thumbnail.Cache
+	providers thumbnail.Providers
+	resolver  thumbnail.PlaylistResolver
+	fetcher   *thumbnail.Fetcher
+}
+
+type testServiceOption func(*testServiceOptions)
+
+// withFileCache stores the cache in a file rather than in memory. In-memory
+// databases are not shared between connections.
+func withFileCache() testServiceOption {
+	return func(o *testServiceOptions) { o.file = true }
+}
+
+// withHotTier puts a hot tier in front of the SQLite cache.
+func withHotTier(hot thumbnail.Cache) testServiceOption {
+	return func(o *testServiceOptions) { o.hot = hot }
+}
+
+// withProviders replaces the YouTube provider of the test server.
+func withProviders(providers thumbnail.Providers) testServiceOption {
+	return func(o *testServiceOptions) { o.providers = providers }
+}
+
+// withResolver sets the playlist resolver of the service.
+func withResolver(resolver thumbnail.PlaylistResolver) testServiceOption {
+	return func(o *testServiceOptions) { o.resolver = resolver }
+}
+
+// withFetcher replaces the default fetcher of the test server's client.
+func withFetcher(fetcher *thumbnail.Fetcher) testServiceOption {
+	return func(o *testServiceOptions) { o.fetcher = fetcher }
+}
+
+// newTestService returns a service that downloads the thumbnails of YouTube
+// videos from a given test server and caches them in a new SQLite cache,
+// which is also returned.
+func newTestService(t *testing.T, srv *httptest.Server, opts ...testServiceOption) (*thumbnail.Service, *thumbnail.SQLiteCache) {
+	t.Helper()
+
+	o := testServiceOptions{
+		providers: thumbnail.Providers{&thumbnail.YouTube{URLTemplate: srv.URL + "/vi/{video_id}/hqdefault.jpg"}},
+		fetcher:   thumbnail.NewFetcherWithClient(srv.Client(), nil),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	path := ":memory:"
+	if o.file {
+		path = filepath.Join(t.TempDir(), "cache.db")
+	}
+	cache, err := thumbnail.OpenSQLiteCache(path, &sqliteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	var c thumbnail.Cache = cache
+	if o.hot != nil {
+		c = thumbnail.NewTieredCache(o.hot, cache)
+	}
+	return thumbnail.NewService(c, o.providers, o.resolver, o.fetcher), cache
+}
+
+func TestServiceGetThumbnail(t *testing.T) {
+	jpegData := newJPEG(t, 480, 360)
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/vi/dQw4w9WgXcQ/hqdefault.jpg", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write(jpegData)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	s, _ := newTestService(t, srv)
+
+	for _, wantStatus := range []youthumbpb.CacheStatus{youthumbpb.CacheStatus_CACHE_STATUS_MISS, youthumbpb.CacheStatus_CACHE_STATUS_HIT} {
+		stream := &thumbnailStream{ctx: context.Background()}
+		req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+		if err := s.GetThumbnail(req, stream); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stream.data(), jpegData) || stream.chunks[0].ContentType != "image/jpeg" {
+			t.Errorf("GetThumbnail() chunks = %v, want jpeg chunks", stream.chunks)
+		}
+		if got := stream.chunks[0].CacheStatus; got != wantStatus {
+			t.Errorf("GetThumbnail() cache status = %s, want %s", got, wantStatus)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d upstream requests, want 1", requests)
+	}
+
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/xxxxxxxxxxx"}
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetThumbnail() error = %v, want NotFound", err)
+	}
+}
+
+func TestServiceGetThumbnailEmpty(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected upstream request")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name  string
+		blobs bool
+	}{
+		{name: "inline"},
+		{name: "blob", blobs: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cache := newTestService(t, srv)
+			if tt.blobs {
+				blobs, err := thumbnail.NewFileBlobStore(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				cache.Blobs = blobs
+			}
+			empty := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte{}, Expiration: time.Now().Add(time.Hour)}
+			if err := cache.SetThumbnail("youtube:dQw4w9WgXcQ", empty); err != nil {
+				t.Fatal(err)
+			}
+
+			// The client still gets the content type and the cache status.
+			stream := &thumbnailStream{ctx: context.Background()}
+			req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+			if err := s.GetThumbnail(req, stream); err != nil {
+				t.Fatal(err)
+			}
+			if len(stream.chunks) != 1 || len(stream.chunks[0].Data) != 0 {
+				t.Fatalf("GetThumbnail() chunks = %v, want a single chunk without data", stream.chunks)
+			}
+			if c := stream.chunks[0]; c.ContentType != "image/jpeg" || c.CacheStatus != youthumbpb.CacheStatus_CACHE_STATUS_HIT {
+				t.Errorf("GetThumbnail() chunk = %v, want the content type and the cache status", c)
+			}
+		})
+	}
+}
+
+func TestServiceGetThumbnailCircuitOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	fetcher := thumbnail.NewFetcherWithClient(srv.Client(), nil)
+	fetcher.Breaker = thumbnail.BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1}
+	s, cache := newTestService(t, srv, withFetcher(fetcher))
+
+	stale := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: []byte("stale"), Expiration: time.Now().Add(-time.Hour)}
+	if err := cache.SetThumbnail("youtube:dQw4w9WgXcQ", stale); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failure opens the breaker.
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/xxxxxxxxxxx"}
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); status.Code(err) != codes.Internal {
+		t.Fatalf("GetThumbnail() error = %v, want Internal", err)
+	}
+
+	// Misses fail fast with retry info.
+	err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()})
+	st := status.Convert(err)
+	if st.Code() != codes.Unavailable || len(st.Details()) != 1 {
+		t.Fatalf("GetThumbnail() error = %v, want Unavailable with retry info", err)
+	}
+	if info, ok := st.Details()[0].(*errdetails.RetryInfo); !ok || info.RetryDelay.AsDuration() <= 0 {
+		t.Errorf("GetThumbnail() details = %v, want RetryInfo", st.Details())
+	}
+
+	// Expired thumbnails are served stale.
+	stream := &thumbnailStream{ctx: context.Background()}
+	req = &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+	if err := s.GetThumbnail(req, stream); err != nil {
+		t.Fatal(err)
+	}
+	if string(stream.data()) != "stale" {
+		t.Errorf("GetThumbnail() chunks = %v, want the stale thumbnail", stream.chunks)
+	}
+}
+
+func TestServiceGetThumbnailCoalescing(t *testing.T) {
+	const clients = 250
+
+	jpegData := newJPEG(t, 480, 360)
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write(jpegData)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, _ := newTestService(t, srv, withFileCache())
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+
+	// A waiter that cancels detaches without aborting the shared download.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() { canceled <- s.GetThumbnail(req, &thumbnailStream{ctx: ctx}) }()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-canceled; status.Code(err) != codes.Canceled {
+		t.Errorf("GetThumbnail() error = %v, want Canceled", err)
+	}
+
+	var started, done sync.WaitGroup
+	errs := make(chan error, clients)
+	started.Add(clients)
+	done.Add(clients)
+	for range clients {
+		go func() {
+			defer done.Done()
+			stream := &thumbnailStream{ctx: context.Background()}
+			started.Done()
+			if err := s.GetThumbnail(req, stream); err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(stream.data(), jpegData) {
+				errs <- fmt.Errorf("got chunks %v, want jpeg chunks", stream.chunks)
+			}
+		}()
+	}
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+}
+
+func TestServiceGetThumbnailValidation(t *testing.T) {
+	jpegData := newJPEG(t, 480, 360)
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 160, 90))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		contentType     string
+		body            []byte
+		wantCode        codes.Code
+		wantContentType string
+		wantWidth       int
+		wantHeight      int
+	}{
+		{name: "jpeg", contentType: "image/jpeg", body: jpegData, wantCode: codes.OK, wantContentType: "image/jpeg", wantWidth: 480, wantHeight: 360},
+		{name: "png", contentType: "image/png", body: pngData.Bytes(), wantCode: codes.OK, wantContentType: "image/png", wantWidth: 160, wantHeight: 90},
+		{name: "mismatched content type", contentType: "image/jpeg", body: pngData.Bytes(), wantCode: codes.OK, wantContentType: "image/png", wantWidth: 160, wantHeight: 90},
+		{name: "missing content type", body: jpegData, wantCode: codes.OK, wantContentType: "image/jpeg", wantWidth: 480, wantHeight: 360},
+		{name: "html error page", contentType: "image/jpeg", body: []byte("<!DOCTYPE html><html><body>Error</body></html>"), wantCode: codes.Internal},
+		{name: "truncated image", contentType: "image/jpeg", body: jpegData[:4], wantCode: codes.Internal},
+		{name: "too large", contentType: "image/jpeg", body: append(jpegData, make([]byte, 4096)...), wantCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tt.contentType}
+				w.Header().Set("Cache-Control", "max-age=3600")
+				_, _ = w.Write(tt.body)
+			}))
+			t.Cleanup(srv.Close)
+
+			s, cache := newTestService(t, srv)
+			s.MaxThumbnailSize = int64(len(jpegData) + 1024)
+
+			stream := &thumbnailStream{ctx: context.Background()}
+			req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+			err := s.GetThumbnail(req, stream)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("GetThumbnail() error = %v, want %s", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				if _, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ"); !errors.Is(err, thumbnail.ErrNotFound) {
+					t.Errorf("GetThumbnail() error = %v, want ErrNotFound", err)
+				}
+				return
+			}
+
+			if stream.chunks[0].ContentType != tt.wantContentType {
+				t.Errorf("GetThumbnail() content type = %q, want %q", stream.chunks[0].ContentType, tt.wantContentType)
+			}
+			cached, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cached.Width != tt.wantWidth || cached.Height != tt.wantHeight {
+				t.Errorf("cached dimensions = %dx%d, want %dx%d", cached.Width, cached.Height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestServiceGetThumbnailRevalidation(t *testing.T) {
+	oldData := newJPEG(t, 480, 360)
+	newData := newJPEG(t, 1280, 720)
+
+	var conditional atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			conditional.Add(1)
+		}
+		switch r.URL.Path {
+		case "/vi/dQw4w9WgXcQ/hqdefault.jpg":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vi/9bZkp7q19f0/hqdefault.jpg":
+			w.Header().Set("ETag", `"v2"`)
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(newData)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, cache := newTestService(t, srv)
+
+	expired := time.Now().Add(-time.Hour)
+	for key, etag := range map[string]string{"youtube:dQw4w9WgXcQ": `"v1"`, "youtube:9bZkp7q19f0": `"v1"`, "youtube:jNQXAC9IVRw": ""} {
+		stale := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: oldData, Expiration: expired, ETag: etag}
+		if err := cache.SetThumbnail(key, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fullFetches := expvar.Get("thumbnail_full_fetches").(*expvar.Int)
+	revalidations := expvar.Get("thumbnail_revalidations").(*expvar.Int)
+	fullFetchesBefore, revalidationsBefore := fullFetches.Value(), revalidations.Value()
+
+	tests := []struct {
+		name     string
+		videoID  string
+		wantData []byte
+		wantETag string
+	}{
+		{name: "not modified", videoID: "dQw4w9WgXcQ", wantData: oldData, wantETag: `"v1"`},
+		{name: "modified", videoID: "9bZkp7q19f0", wantData: newData, wantETag: `"v2"`},
+		{name: "no validators", videoID: "jNQXAC9IVRw", wantData: newData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &thumbnailStream{ctx: context.Background()}
+			req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/" + tt.videoID}
+			if err := s.GetThumbnail(req, stream); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stream.data(), tt.wantData) {
+				t.Errorf("GetThumbnail() returned unexpected data")
+			}
+
+			cached, err := cache.GetThumbnail("youtube:" + tt.videoID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cached.Data, tt.wantData) || cached.ETag != tt.wantETag {
+				t.Errorf("cached thumbnail ETag = %s, want %s", cached.ETag, tt.wantETag)
+			}
+		})
+	}
+
+	if n := conditional.Load(); n != 2 {
+		t.Errorf("got %d conditional requests, want 2", n)
+	}
+	if n := revalidations.Value() - revalidationsBefore; n != 1 {
+		t.Errorf("got %d revalidations, want 1", n)
+	}
+	if n := fullFetches.Value() - fullFetchesBefore; n != 2 {
+		t.Errorf("got %d full fetches, want 2", n)
+	}
+}
+
+func TestServiceGetThumbnailStale(t *testing.T) {
+	oldData := newJPEG(t, 480, 360)
+	newData := newJPEG(t, 1280, 720)
+
+	tests := []struct {
+		name                 string
+		upstreamStatus       int
+		expiredFor           time.Duration
+		staleWhileRevalidate time.Duration
+		staleIfError         time.Duration
+		wantCode             codes.Code
+		wantStatus           youthumbpb.CacheStatus
+		wantData             []byte
+		wantRefresh          bool
+	}{
+		{name: "stale while revalidate", upstreamStatus: http.StatusOK, expiredFor: time.Minute, staleWhileRevalidate: time.Hour, wantStatus: youthumbpb.CacheStatus_CACHE_STATUS_STALE, wantData: oldData, wantRefresh: true},
+		{name: "expired beyond stale while revalidate", upstreamStatus: http.StatusOK, expiredFor: 2 * time.Hour, staleWhileRevalidate: time.Hour, wantStatus: youthumbpb.CacheStatus_CACHE_STATUS_MISS, wantData: newData},
+		{name: "stale if error", upstreamStatus: http.StatusInternalServerError, expiredFor: time.Minute, staleIfError: time.Hour, wantStatus: youthumbpb.CacheStatus_CACHE_STATUS_STALE, wantData: oldData},
+		{name: "expired beyond stale if error", upstreamStatus: http.StatusInternalServerError, expiredFor: 2 * time.Hour, staleIfError: time.Hour, wantCode: codes.Internal},
+		{name: "stale if error for not found", upstreamStatus: http.StatusNotFound, expiredFor: time.Minute, staleIfError: time.Hour, wantCode: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.upstreamStatus != http.StatusOK {
+					w.WriteHeader(tt.upstreamStatus)
+					return
+				}
+				w.Header().Set("Content-Type", "image/jpeg")
+				w.Header().Set("Cache-Control", "max-age=3600")
+				_, _ = w.Write(newData)
+			}))
+			t.Cleanup(srv.Close)
+
+			s, cache := newTestService(t, srv, withFileCache())
+			s.StaleWhileRevalidate = tt.staleWhileRevalidate
+			s.StaleIfError = tt.staleIfError
+
+			stale := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: oldData, Expiration: time.Now().Add(-tt.expiredFor)}
+			if err := cache.SetThumbnail("youtube:dQw4w9WgXcQ", stale); err != nil {
+				t.Fatal(err)
+			}
+
+			stream := &thumbnailStream{ctx: context.Background()}
+			req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+			err := s.GetThumbnail(req, stream)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("GetThumbnail() error = %v, want %s", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if !bytes.Equal(stream.data(), tt.wantData) {
+				t.Errorf("GetThumbnail() returned unexpected data")
+			}
+			if got := stream.chunks[0].CacheStatus; got != tt.wantStatus {
+				t.Errorf("GetThumbnail() cache status = %s, want %s", got, tt.wantStatus)
+			}
+
+			if tt.wantRefresh {
+				deadline := time.Now().Add(5 * time.Second)
+				for {
+					cached, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ")
+					if err == nil && bytes.Equal(cached.Data, newData) {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatal("thumbnail wasn't refreshed in the background")
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceGetThumbnailNotFound(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, cache := newTestService(t, srv)
+	s.NotFoundTTL = time.Hour
+
+	get := func() {
+		t.Helper()
+		req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+		if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); status.Code(err) != codes.NotFound {
+			t.Fatalf("GetThumbnail() error = %v, want NotFound", err)
+		}
+	}
+
+	get()
+	get()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+
+	n, err := cache.ClearNotFound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("ClearNotFound() = %d, want 1", n)
+	}
+
+	get()
+	if n := requests.Load(); n != 2 {
+		t.Errorf("got %d upstream requests, want 2", n)
+	}
+}
+
+func TestServiceGetThumbnailPlaceholder(t *testing.T) {
+	placeholder := newJPEG(t, 120, 90)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(placeholder)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, cache := newTestService(t, srv)
+
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetThumbnail() error = %v, want NotFound", err)
+	}
+	if _, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want the placeholder not to be cached", err)
+	}
+}
+
+func TestServiceGetThumbnailStreaming(t *testing.T) {
+	jpegData := newJPEG(t, 1280, 720)
+	head, tail := jpegData[:len(jpegData)/2], jpegData[len(jpegData)/2:]
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write(head)
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write(tail)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, cache := newTestService(t, srv, withFileCache())
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+
+	// Both the first client and the client that joins mid-flight get chunks
+	// before upstream sends the rest of the thumbnail.
+	streams := []*thumbnailStream{
+		{ctx: context.Background(), sent: make(chan struct{}, 64)},
+		{ctx: context.Background(), sent: make(chan struct{}, 64)},
+	}
+	errs := make(chan error, len(streams))
+	for _, stream := range streams {
+		go func() { errs <- s.GetThumbnail(req, stream) }()
+		select {
+		case <-stream.sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no chunk sent before the download completed")
+		}
+	}
+
+	if _, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want the incomplete thumbnail not to be cached", err)
+	}
+
+	close(release)
+	for range streams {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, stream := range streams {
+		if !bytes.Equal(stream.data(), jpegData) {
+			t.Errorf("GetThumbnail() returned unexpected data")
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d upstream requests, want 1", n)
+	}
+
+	cached, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached.Data, jpegData) {
+		t.Errorf("cached data differs from upstream data")
+	}
+}
+
+func TestServiceGetThumbnailTruncated(t *testing.T) {
+	jpegData := newJPEG(t, 1280, 720)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Length", fmt.Sprint(len(jpegData)))
+		_, _ = w.Write(jpegData[:len(jpegData)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, cache := newTestService(t, srv)
+
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); status.Code(err) != codes.Internal {
+		t.Fatalf("GetThumbnail() error = %v, want Internal", err)
+	}
+	if _, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want the truncated thumbnail not to be cached", err)
+	}
+}
+
+func TestServiceGetThumbnailDownloadTimeout(t *testing.T) {
+	jpegData := newJPEG(t, 1280, 720)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write(jpegData[:len(jpegData)/2])
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	s, cache := newTestService(t, srv)
+	s.DownloadTimeout = 100 * time.Millisecond
+
+	// The download is bounded even though the request has no deadline.
+	errs := make(chan error, 1)
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+	go func() { errs <- s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}) }()
+	select {
+	case err := <-errs:
+		if status.Code(err) != codes.Internal {
+			t.Errorf("GetThumbnail() error = %v, want Internal", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download wasn't aborted after its timeout")
+	}
+	if _, err := cache.GetThumbnail("youtube:dQw4w9WgXcQ"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("GetThumbnail() error = %v, want the incomplete thumbnail not to be cached", err)
+	}
+}
+
+func TestServiceGetThumbnailLeaderDeadline(t *testing.T) {
+	jpegData := newJPEG(t, 1280, 720)
+	requested := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write(jpegData)
+	}))
+	t.Cleanup(srv.Close)
+
+	s, _ := newTestService(t, srv)
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+
+	// The request that starts the download gives up before it completes.
+	first := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		first <- s.GetThumbnail(req, &thumbnailStream{ctx: ctx})
+	}()
+	<-requested
+
+	// A request with a later deadline joins the download and gets the
+	// thumbnail.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := &thumbnailStream{ctx: ctx}
+	if err := s.GetThumbnail(req, stream); err != nil {
+		t.Fatalf("GetThumbnail() error = %v, want the thumbnail", err)
+	}
+	if got := stream.data(); !bytes.Equal(got, jpegData) {
+		t.Errorf("GetThumbnail() data = %d bytes, want %d bytes", len(got), len(jpegData))
+	}
+	if err := <-first; status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("GetThumbnail() error = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestServiceGetThumbnailRateLimited(t *testing.T) {
+	jpegData := newJPEG(t, 1280, 720)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(jpegData)
+	}))
+	t.Cleanup(srv.Close)
+
+	fetcher := thumbnail.NewFetcherWithClient(srv.Client(), nil)
+	fetcher.Limit = thumbnail.LimitPolicy{HostRate: 2, HostBurst: 1}
+	s, _ := newTestService(t, srv, withFetcher(fetcher))
+
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The download of another video is queued for the next token, which isn't
+	// available before the deadline of the request.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req = &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/jNQXAC9IVRw"}
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: ctx}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("GetThumbnail() error = %v, want ResourceExhausted", err)
+	}
+	// Requests without a deadline wait for the token.
+	if err := s.GetThumbnail(req, &thumbnailStream{ctx: context.Background()}); err != nil {
+		t.Errorf("GetThumbnail() error = %v, want the thumbnail", err)
+	}
+}
+
+// gatedBlobStore is a blob store whose blobs are read past their first chunk
+// only once release is closed.
+type gatedBlobStore struct {
+	thumbnail.BlobStore
+	release chan struct{}
+}
+
+func (s *gatedBlobStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	body, err := s.BlobStore.Open(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	// The first chunk is 64 KiB, the max chunk size of the service.
+	head := io.LimitReader(body, 64<<10)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(head, &gatedReader{Reader: body, release: s.release}), body}, nil
+}
+
+// gatedReader is a reader that blocks until release is closed.
+type gatedReader struct {
+	io.Reader
+	release chan struct{}
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	<-r.release
+	return r.Reader.Read(p)
+}
+
+func TestServiceGetThumbnailStreamedHit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected upstream request")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+
+	hot := thumbnail.NewMemoryCache(1 << 20)
+	s, cold := newTestService(t, srv, withFileCache(), withHotTier(hot))
+	blobs, err := thumbnail.NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	cold.Blobs = &gatedBlobStore{BlobStore: blobs, release: release}
+
+	data := bytes.Repeat([]byte("jpeg"), 50<<10)
+	th := &thumbnail.Thumbnail{ContentType: "image/jpeg", Data: data, Expiration: time.Now().Add(time.Hour)}
+	if err := cold.SetThumbnail("youtube:dQw4w9WgXcQ", th); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &youthumbpb.GetThumbnailRequest{VideoUrl: "https://youtu.be/dQw4w9WgXcQ"}
+
+	// A chunk is sent before the rest of the blob is read.
+	stream := &thumbnailStream{ctx: context.Background(), sent: make(chan struct{}, 64)}
+	errs := make(chan error, 1)
+	go func() { errs <- s.GetThumbnail(req, stream) }()
+	select {
+	case <-stream.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no chunk sent before the blob was read in full")
+	}
+	if _, err := hot.GetThumbnail("youtube:dQw4w9WgXcQ"); !errors.Is(err, thumbnail.ErrNotFound) {
+		t.Errorf("hot GetThumbnail() error = %v, want the partly read thumbnail not to be copied", err)
+	}
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stream.data(), data) || stream.chunks[0].ContentType != "image/jpeg" {
+		t.Errorf("GetThumbnail() returned unexpected data")
+	}
+	if got := stream.chunks[0].CacheStatus; got != youthumbpb.CacheStatus_CACHE_STATUS_HIT {
+		t.Errorf("GetThumbnail() cache status = %s, want %s", got, youthumbpb.CacheStatus_CACHE_STATUS_HIT)
+	}
+	if got, err := hot.GetThumbnail("youtube:dQw4w9WgXcQ"); err != nil || !bytes.Equal(got.Data, data) {
+		t.Errorf("hot GetThumbnail() = %v, want the thumbnail copied once read in full", err)
+	}
+}
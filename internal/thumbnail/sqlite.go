@@ -0,0 +1,615 @@
+package thumbnail
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kirillgashkov/assignment-youthumb/internal/app/config"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// maxSQLiteVariables is the max number of parameters of a SQLite statement.
+const maxSQLiteVariables = 999
+
+var (
+	// evictions exposes the number of thumbnails evicted from the SQLite
+	// caches at /debug/vars.
+	evictions = expvar.NewInt("cache_evictions")
+)
+
+// Eviction policies of SQLiteCache.
+const (
+	// EvictionLRU evicts the least recently used thumbnails first.
+	EvictionLRU = "lru"
+	// EvictionLFU evicts the least frequently used thumbnails first, the least
+	// recently used first among equally used ones.
+	EvictionLFU = "lfu"
+)
+
+// SQLiteCache is a cache for thumbnail images stored in a SQLite database.
+//
+// The cache tracks the last access time and the number of hits of every
+// thumbnail. Accesses are buffered and recorded in batches in the background,
+// so that reads don't wait for the writer. If a write makes the cache exceed
+// MaxSize or MaxEntries, thumbnails other than the written one are evicted by
+// the Eviction policy in the same transaction.
+//
+// Writes go through a single connection, so they queue in the pool instead of
+// failing on the database lock, and reads go through a pool of read-only
+// connections, which in WAL mode don't wait for writes.
+//
+// If Blobs is set, thumbnail data is stored in the blob store and the database
+// only references it by hash. The limits apply to the thumbnails, not to the
+// deduplicated blobs.
+type SQLiteCache struct {
+	// MaxSize is the max total size of thumbnail data in bytes, zero means no
+	// limit.
+	MaxSize int64
+	// MaxEntries is the max number of thumbnails, zero means no limit.
+	MaxEntries int64
+	// Eviction is the eviction policy, EvictionLRU or EvictionLFU. Empty
+	// means EvictionLRU.
+	Eviction string
+	// Blobs is the store of thumbnail data. If nil, thumbnail data is stored
+	// in the database. Thumbnails stored in either way are read regardless.
+	Blobs BlobStore
+
+	// writer is the single-connection pool of writes and reader is the pool
+	// of reads. They are the same pool if reads are served by the writer.
+	writer *sql.DB
+	reader *sql.DB
+
+	// Statements of frequent queries prepared at open time.
+	getStmt         *sql.Stmt
+	accessStmt      *sql.Stmt
+	setStmt         *sql.Stmt
+	extendStmt      *sql.Stmt
+	isNotFoundStmt  *sql.Stmt
+	setNotFoundStmt *sql.Stmt
+
+	accesses *accessLog
+}
+
+// OpenSQLiteCache opens a new SQLite cache with the connection settings of a
+// given config. The schema of the database is migrated to SchemaVersion. If the
+// database was migrated by a newer version, it returns an error wrapping
+// ErrSchemaTooNew.
+// The given DSN must be a SQLite DSN.
+func OpenSQLiteCache(dsn string, cfg *config.SQLiteConfig) (*SQLiteCache, error) {
+	writer, err := sql.Open("sqlite3", sqliteDSN(dsn, cfg, false))
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	c := &SQLiteCache{writer: writer, reader: writer}
+
+	if _, err := migrate(writer, false); err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+	incremental, err := c.isIncrementalVacuum()
+	if err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+	if !incremental {
+		slog.Warn("database was created without incremental auto-vacuum, free pages are not returned to the file system until it's converted with -migrate-only", "dsn", dsn)
+	}
+
+	// Connections to an in-memory database open separate databases.
+	if cfg.MaxReaders > 0 && !isMemorySQLiteDSN(dsn) {
+		reader, err := sql.Open("sqlite3", sqliteDSN(dsn, cfg, true))
+		if err != nil {
+			return nil, errors.Join(err, c.Close())
+		}
+		reader.SetMaxOpenConns(cfg.MaxReaders)
+		reader.SetMaxIdleConns(cfg.MaxReaders)
+		c.reader = reader
+	}
+
+	if err := c.prepare(); err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+	c.accesses = newAccessLog(c.writeAccesses)
+	return c, nil
+}
+
+// prepare prepares the statements of frequent queries.
+func (c *SQLiteCache) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		db    *sql.DB
+		query string
+	}{
+		{&c.getStmt, c.reader, `
+			SELECT content_type, data, expires_at, width, height, etag, last_modified, blob_hash
+			FROM cache WHERE video_id = ?
+		`},
+		{&c.accessStmt, c.writer, `UPDATE cache SET accessed_at = max(accessed_at, ?), hits = hits + ? WHERE video_id = ?`},
+		{&c.setStmt, c.writer, `
+			INSERT INTO cache (video_id, content_type, data, expires_at, width, height, etag, last_modified, accessed_at, size, blob_hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (video_id) DO UPDATE SET
+				content_type = excluded.content_type,
+				data = excluded.data,
+				expires_at = excluded.expires_at,
+				width = excluded.width,
+				height = excluded.height,
+				etag = excluded.etag,
+				last_modified = excluded.last_modified,
+				accessed_at = excluded.accessed_at,
+				size = excluded.size,
+				blob_hash = excluded.blob_hash
+		`},
+		{&c.extendStmt, c.writer, `UPDATE cache SET expires_at = ?, etag = ?, last_modified = ? WHERE video_id = ?`},
+		{&c.isNotFoundStmt, c.reader, `SELECT EXISTS (SELECT 1 FROM not_found WHERE video_id = ? AND expires_at > ?)`},
+		{&c.setNotFoundStmt, c.writer, `INSERT OR REPLACE INTO not_found (video_id, expires_at) VALUES (?, ?)`},
+	}
+	for _, s := range statements {
+		stmt, err := s.db.Prepare(s.query)
+		if err != nil {
+			return err
+		}
+		*s.stmt = stmt
+	}
+	return nil
+}
+
+// sqliteDSN returns a DSN with the connection settings of a given config. The
+// settings apply to every connection of a pool. Writers begin transactions
+// with the write lock, so that they never fail to upgrade a read lock, and
+// readers can't write.
+func sqliteDSN(dsn string, cfg *config.SQLiteConfig, readOnly bool) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	if readOnly {
+		params.Set("_query_only", "true")
+	} else {
+		params.Set("_txlock", "immediate")
+		// Space freed by deleted rows is returned to the file system by
+		// IncrementalVacuum. It only applies to new databases, existing ones
+		// are converted by EnableIncrementalVacuum, so it's set before the
+		// journal mode writes the header of a new database.
+		params.Set("_auto_vacuum", "incremental")
+		// The journal mode is persistent, so readers follow the writer.
+		if cfg.JournalMode != "" {
+			params.Set("_journal_mode", cfg.JournalMode)
+		}
+	}
+	if cfg.Synchronous != "" {
+		params.Set("_synchronous", cfg.Synchronous)
+	}
+
+	return withSQLiteParams(dsn, params)
+}
+
+// withSQLiteParams returns a DSN with the given connection parameters added.
+func withSQLiteParams(dsn string, params url.Values) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params.Encode()
+	}
+	return dsn + "?" + params.Encode()
+}
+
+// isMemorySQLiteDSN reports whether a DSN is of an in-memory or a temporary
+// database, which is private to a connection.
+func isMemorySQLiteDSN(dsn string) bool {
+	name, query, _ := strings.Cut(dsn, "?")
+	return name == "" || name == ":memory:" || name == "file::memory:" || strings.Contains(query, "mode=memory")
+}
+
+// PendingMigrations returns the migrations OpenSQLiteCache would apply to a
+// database without applying them. The database is neither created nor written,
+// if its file doesn't exist, it returns an error wrapping fs.ErrNotExist.
+// The given DSN must be a SQLite DSN.
+func PendingMigrations(dsn string) ([]Migration, error) {
+	if !isMemorySQLiteDSN(dsn) {
+		name, _, _ := strings.Cut(dsn, "?")
+		if _, err := os.Stat(strings.TrimPrefix(name, "file:")); err != nil {
+			return nil, err
+		}
+	}
+	params := url.Values{}
+	params.Set("_query_only", "true")
+
+	db, err := sql.Open("sqlite3", withSQLiteParams(dsn, params))
+	if err != nil {
+		return nil, err
+	}
+	pending, err := migrate(db, true)
+	return pending, errors.Join(err, db.Close())
+}
+
+// Close implements Cache. It records the buffered accesses first.
+func (c *SQLiteCache) Close() error {
+	var errs []error
+	if c.accesses != nil {
+		errs = append(errs, c.accesses.close())
+	}
+	for _, stmt := range []*sql.Stmt{c.getStmt, c.accessStmt, c.setStmt, c.extendStmt, c.isNotFoundStmt, c.setNotFoundStmt} {
+		if stmt != nil {
+			errs = append(errs, stmt.Close())
+		}
+	}
+	if c.reader != c.writer {
+		errs = append(errs, c.reader.Close())
+	}
+	errs = append(errs, c.writer.Close())
+	return errors.Join(errs...)
+}
+
+// GetThumbnail implements Cache. A thumbnail whose blob is missing is not
+// found.
+func (c *SQLiteCache) GetThumbnail(videoID string) (*Thumbnail, error) {
+	t, hash, err := c.get(videoID)
+	if err != nil || hash == "" {
+		return t, err
+	}
+	if t.Data, err = readBlob(c.Blobs, videoID, hash); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// OpenThumbnail implements ThumbnailOpener. The data of a thumbnail stored in
+// the blob store is streamed from it.
+func (c *SQLiteCache) OpenThumbnail(ctx context.Context, videoID string) (*Thumbnail, io.ReadCloser, error) {
+	t, hash, err := c.get(videoID)
+	if err != nil || hash == "" {
+		return t, nil, err
+	}
+	body, err := openBlob(ctx, c.Blobs, videoID, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, body, nil
+}
+
+// get returns a thumbnail and the hash of its blob, if any, and buffers the
+// access.
+func (c *SQLiteCache) get(videoID string) (*Thumbnail, string, error) {
+	t, hash, err := scanThumbnail(c.getStmt.QueryRow(videoID))
+	if err != nil {
+		return nil, "", err
+	}
+	c.accesses.record(videoID, time.Now())
+	return t, hash, nil
+}
+
+// RecordAccess implements AccessRecorder.
+func (c *SQLiteCache) RecordAccess(videoID string, at time.Time) {
+	c.accesses.record(videoID, at)
+}
+
+// writeAccesses records a batch of accesses in a single transaction.
+func (c *SQLiteCache) writeAccesses(accesses map[string]access) error {
+	tx, err := c.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	if err := applyAccesses(tx.Stmt(c.accessStmt), accesses); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyAccesses records a batch of accesses by a prepared statement of access
+// time, hits and video ID. Accesses of thumbnails evicted in the meantime are
+// ignored.
+func applyAccesses(stmt *sql.Stmt, accesses map[string]access) error {
+	for videoID, a := range accesses {
+		if _, err := stmt.Exec(a.at, a.hits, videoID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetThumbnail implements Cache. A replaced thumbnail keeps its hits. If the
+// cache exceeds its limits, other thumbnails are evicted.
+func (c *SQLiteCache) SetThumbnail(videoID string, t *Thumbnail) error {
+	// The blob is written before the transaction, so that the write lock isn't
+	// held during blob store writes.
+	data, hash := t.Data, ""
+	if c.Blobs != nil {
+		var err error
+		if hash, err = c.Blobs.Put(t); err != nil {
+			return err
+		}
+		data = []byte{}
+	}
+
+	tx, err := c.writer.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	_, err = tx.Stmt(c.setStmt).Exec(videoID, t.ContentType, data, t.Expiration.Unix(), t.Width, t.Height, t.ETag, t.LastModified, time.Now().UnixMilli(), len(t.Data), hash)
+	if err != nil {
+		return err
+	}
+
+	// The insert holds the write lock, so concurrent writes can't interleave
+	// with the eviction. The buffered accesses are recorded first, so that the
+	// eviction policy sees them.
+	if err := applyAccesses(tx.Stmt(c.accessStmt), c.accesses.take()); err != nil {
+		slog.Error("failed to record thumbnail accesses", "error", err)
+	}
+	n, err := c.evict(tx, videoID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	evictions.Add(n)
+	return nil
+}
+
+// evict deletes thumbnails other than keptVideoID by the eviction policy until
+// the cache fits its limits and returns the number of deleted thumbnails.
+func (c *SQLiteCache) evict(tx *sql.Tx, keptVideoID string) (int64, error) {
+	if c.MaxSize <= 0 && c.MaxEntries <= 0 {
+		return 0, nil
+	}
+
+	var entries, size int64
+	if err := tx.QueryRow(`SELECT entries, bytes FROM cache_stats`).Scan(&entries, &size); err != nil {
+		return 0, err
+	}
+	excessEntries, excessSize := int64(0), int64(0)
+	if c.MaxEntries > 0 {
+		excessEntries = entries - c.MaxEntries
+	}
+	if c.MaxSize > 0 {
+		excessSize = size - c.MaxSize
+	}
+	if excessEntries <= 0 && excessSize <= 0 {
+		return 0, nil
+	}
+
+	order := "accessed_at, video_id"
+	if c.Eviction == EvictionLFU {
+		order = "hits, accessed_at, video_id"
+	}
+	rows, err := tx.Query(`SELECT video_id, size FROM cache WHERE video_id != ? ORDER BY `+order, keptVideoID)
+	if err != nil {
+		return 0, err
+	}
+	var victims []any
+	for rows.Next() && (excessEntries > 0 || excessSize > 0) {
+		var victim string
+		var victimSize int64
+		if err := rows.Scan(&victim, &victimSize); err != nil {
+			return 0, errors.Join(err, rows.Close())
+		}
+		victims = append(victims, victim)
+		excessEntries--
+		excessSize -= victimSize
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return 0, err
+	}
+
+	n := int64(len(victims))
+	for len(victims) > 0 {
+		chunk := victims[:min(len(victims), maxSQLiteVariables)]
+		victims = victims[len(chunk):]
+		query := `DELETE FROM cache WHERE video_id IN (?` + strings.Repeat(", ?", len(chunk)-1) + `)`
+		if _, err := tx.Exec(query, chunk...); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// rollback rolls back a transaction unless it's committed.
+func rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		slog.Error("failed to roll back transaction", "error", err)
+	}
+}
+
+// ExtendThumbnail implements Cache.
+func (c *SQLiteCache) ExtendThumbnail(videoID string, t *Thumbnail) error {
+	res, err := c.extendStmt.Exec(t.Expiration.Unix(), t.ETag, t.LastModified, videoID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// IsNotFound implements Cache.
+func (c *SQLiteCache) IsNotFound(videoID string) (bool, error) {
+	var exists bool
+	if err := c.isNotFoundStmt.QueryRow(videoID, time.Now().Unix()).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SetNotFound implements Cache.
+func (c *SQLiteCache) SetNotFound(videoID string, expiration time.Time) error {
+	if _, err := c.setNotFoundStmt.Exec(videoID, expiration.Unix()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ClearNotFound implements Cache.
+func (c *SQLiteCache) ClearNotFound() (int64, error) {
+	res, err := c.writer.Exec(`DELETE FROM not_found`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpired implements Sweeper.
+func (c *SQLiteCache) DeleteExpired(before time.Time, limit int) (int64, int64, error) {
+	query := `
+		DELETE FROM cache WHERE video_id IN (SELECT video_id FROM cache WHERE expires_at < ? LIMIT ?)
+		RETURNING size
+	`
+	rows, err := c.writer.Query(query, before.Unix(), limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	var n, size int64
+	for rows.Next() {
+		var rowSize int64
+		if err := rows.Scan(&rowSize); err != nil {
+			return 0, 0, errors.Join(err, rows.Close())
+		}
+		n++
+		size += rowSize
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return 0, 0, err
+	}
+
+	query = `DELETE FROM not_found WHERE video_id IN (SELECT video_id FROM not_found WHERE expires_at < ? LIMIT ?)`
+	res, err := c.writer.Exec(query, before.Unix(), limit)
+	if err != nil {
+		return n, size, err
+	}
+	notFound, err := res.RowsAffected()
+	if err != nil {
+		return n, size, err
+	}
+
+	return n + notFound, size, nil
+}
+
+// CollectBlobs deletes the blobs modified before a given time that no
+// thumbnail references, e.g. the blobs of evicted, expired or replaced
+// thumbnails. It returns the number and the total size of the deleted blobs.
+// If Blobs is nil, it does nothing.
+func (c *SQLiteCache) CollectBlobs(ctx context.Context, before time.Time) (int64, int64, error) {
+	if c.Blobs == nil {
+		return 0, 0, nil
+	}
+	return collectBlobs(ctx, c.reader, c.Blobs, before)
+}
+
+// IncrementalVacuum returns at most pages free pages of the database file to
+// the file system and returns the number of reclaimed bytes. Zero pages means
+// all free pages. Databases created without incremental auto-vacuum are not
+// vacuumed, see EnableIncrementalVacuum.
+func (c *SQLiteCache) IncrementalVacuum(pages int) (int64, error) {
+	conn, err := c.writer.Conn(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer func(conn *sql.Conn) {
+		if err := conn.Close(); err != nil {
+			slog.Error("failed to close connection", "error", err)
+		}
+	}(conn)
+
+	var pageSize, before, after int64
+	if err := conn.QueryRowContext(context.Background(), `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	if err := conn.QueryRowContext(context.Background(), `PRAGMA freelist_count`).Scan(&before); err != nil {
+		return 0, err
+	}
+
+	// The pragma frees a page per step, so the rows are drained.
+	rows, err := conn.QueryContext(context.Background(), fmt.Sprintf(`PRAGMA incremental_vacuum(%d)`, pages))
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+	}
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return 0, err
+	}
+
+	if err := conn.QueryRowContext(context.Background(), `PRAGMA freelist_count`).Scan(&after); err != nil {
+		return 0, err
+	}
+	return (before - after) * pageSize, nil
+}
+
+// EnableIncrementalVacuum converts a database created without incremental
+// auto-vacuum, so that IncrementalVacuum returns its free pages to the file
+// system. It reports whether the database was converted. The conversion
+// rebuilds the whole database file with VACUUM, which blocks other writers and
+// needs as much free disk space as the size of the database.
+func (c *SQLiteCache) EnableIncrementalVacuum() (bool, error) {
+	incremental, err := c.isIncrementalVacuum()
+	if err != nil || incremental {
+		return false, err
+	}
+	if _, err := c.writer.Exec(`PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return false, err
+	}
+	if _, err := c.writer.Exec(`VACUUM`); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isIncrementalVacuum reports whether the database has incremental
+// auto-vacuum. The mode of a new database is set by its DSN, see sqliteDSN.
+func (c *SQLiteCache) isIncrementalVacuum() (bool, error) {
+	var mode int
+	if err := c.writer.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return false, err
+	}
+	// 2 is INCREMENTAL, 0 is NONE and 1 is FULL.
+	return mode == 2, nil
+}
+
+// scanThumbnail scans a thumbnail and its blob hash from a row of content
+// type, data, expiration, width, height, ETag, Last-Modified and blob hash.
+// If the row is empty, it returns ErrNotFound.
+func scanThumbnail(row *sql.Row) (*Thumbnail, string, error) {
+	var contentType string
+	var data []byte
+	var expiration int64
+	var width, height int
+	var etag, lastModified string
+	var hash string
+	err := row.Scan(&contentType, &data, &expiration, &width, &height, &etag, &lastModified, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := &Thumbnail{
+		ContentType:  contentType,
+		Data:         data,
+		Expiration:   time.Unix(expiration, 0),
+		Width:        width,
+		Height:       height,
+		ETag:         etag,
+		LastModified: lastModified,
+	}
+	return t, hash, nil
+}
@@ -15,4 +15,11 @@ type Thumbnail struct {
 	ContentType string
 	Data        []byte
 	Expiration  time.Time
+	// Width and Height are the dimensions of the image in pixels.
+	Width  int
+	Height int
+	// ETag and LastModified are the validators of the upstream response used
+	// to revalidate the thumbnail once it expires. They may be empty.
+	ETag         string
+	LastModified string
 }
@@ -0,0 +1,220 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// TieredCache is a two-tier cache: a fast hot tier, e.g. a MemoryCache, in
+// front of a persistent cold tier, e.g. a SQLiteCache. Reads go to the hot
+// tier first and thumbnails found in the cold tier are copied to the hot tier
+// (read-through). Writes go to the cold tier and then to the hot tier
+// (write-through).
+//
+// Reads served by the hot tier are recorded in the cold tier if it's an
+// AccessRecorder, so that its eviction policy sees them.
+//
+// The cold tier is the source of truth: failures of the hot tier, e.g. an
+// unavailable Redis, are logged and treated as misses, and a write fails only
+// if it fails in the cold tier.
+type TieredCache struct {
+	hot  Cache
+	cold Cache
+}
+
+// entryLimiter is a Cache that doesn't store thumbnails larger than a max size,
+// e.g. MemoryCache.
+type entryLimiter interface {
+	maxEntrySize() int64
+}
+
+// NewTieredCache creates a new two-tier cache.
+func NewTieredCache(hot, cold Cache) *TieredCache {
+	return &TieredCache{hot: hot, cold: cold}
+}
+
+// Close implements Cache. It closes both tiers.
+func (c *TieredCache) Close() error {
+	return errors.Join(c.hot.Close(), c.cold.Close())
+}
+
+// GetThumbnail implements Cache.
+func (c *TieredCache) GetThumbnail(videoID string) (*Thumbnail, error) {
+	if t, ok := c.getHot(videoID); ok {
+		return t, nil
+	}
+
+	t, err := c.cold.GetThumbnail(videoID)
+	if err != nil {
+		return nil, err
+	}
+	c.setHot(videoID, t)
+	return t, nil
+}
+
+// getHot returns a thumbnail from the hot tier and whether it was found there.
+// A found thumbnail's access is recorded in the cold tier.
+func (c *TieredCache) getHot(videoID string) (*Thumbnail, bool) {
+	t, err := c.hot.GetThumbnail(videoID)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			slog.Error("failed to get thumbnail from hot tier", "video_id", videoID, "error", err)
+		}
+		return nil, false
+	}
+	c.RecordAccess(videoID, time.Now())
+	return t, true
+}
+
+// RecordAccess implements AccessRecorder. It records the access in the cold
+// tier, if it's an AccessRecorder.
+func (c *TieredCache) RecordAccess(videoID string, at time.Time) {
+	if cold, ok := c.cold.(AccessRecorder); ok {
+		cold.RecordAccess(videoID, at)
+	}
+}
+
+// setHot sets a thumbnail in the hot tier. A failure is only logged, the
+// thumbnail is then read from the cold tier.
+func (c *TieredCache) setHot(videoID string, t *Thumbnail) {
+	if err := c.hot.SetThumbnail(videoID, t); err != nil {
+		slog.Error("failed to set thumbnail in hot tier", "video_id", videoID, "error", err)
+	}
+}
+
+// OpenThumbnail implements ThumbnailOpener. A thumbnail streamed from the cold
+// tier is copied to the hot tier once it's read in full, unless it's larger
+// than the hot tier stores.
+func (c *TieredCache) OpenThumbnail(ctx context.Context, videoID string) (*Thumbnail, io.ReadCloser, error) {
+	if t, ok := c.getHot(videoID); ok {
+		return t, nil, nil
+	}
+
+	var (
+		t    *Thumbnail
+		body io.ReadCloser
+		err  error
+	)
+	if cold, ok := c.cold.(ThumbnailOpener); ok {
+		t, body, err = cold.OpenThumbnail(ctx, videoID)
+	} else {
+		t, err = c.cold.GetThumbnail(videoID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		b := &readThroughBody{body: body, cache: c.hot, videoID: videoID, t: *t}
+		if l, ok := c.hot.(entryLimiter); ok {
+			b.maxSize = l.maxEntrySize()
+		}
+		return t, b, nil
+	}
+	c.setHot(videoID, t)
+	return t, nil, nil
+}
+
+// readThroughBody is the data of a thumbnail streamed from the cold tier. The
+// data is kept as it's read and the thumbnail is set in the hot tier once the
+// data is read to the end.
+type readThroughBody struct {
+	body    io.ReadCloser
+	cache   Cache
+	videoID string
+	t       Thumbnail
+	// maxSize is the max size of the data the hot tier stores, zero means no
+	// limit. Larger data is streamed without being kept.
+	maxSize int64
+	data    bytes.Buffer
+	// done reports whether the data is no longer kept, because the thumbnail
+	// is set in the hot tier or is too large for it.
+	done bool
+}
+
+// Read implements io.Reader.
+func (b *readThroughBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.done {
+		return n, err
+	}
+	if b.maxSize > 0 && int64(b.data.Len()+n) > b.maxSize {
+		b.done = true
+		b.data = bytes.Buffer{}
+		return n, err
+	}
+	b.data.Write(p[:n])
+	if err == io.EOF {
+		b.done = true
+		b.t.Data = b.data.Bytes()
+		if setErr := b.cache.SetThumbnail(b.videoID, &b.t); setErr != nil {
+			slog.Error("failed to set thumbnail in hot tier", "video_id", b.videoID, "error", setErr)
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *readThroughBody) Close() error {
+	return b.body.Close()
+}
+
+// SetThumbnail implements Cache.
+func (c *TieredCache) SetThumbnail(videoID string, t *Thumbnail) error {
+	if err := c.cold.SetThumbnail(videoID, t); err != nil {
+		return err
+	}
+	c.setHot(videoID, t)
+	return nil
+}
+
+// ExtendThumbnail implements Cache. The thumbnail is extended in every tier
+// that has it, e.g. a thumbnail evicted from the cold tier is still extended
+// in the hot tier, so that it isn't revalidated again. It returns ErrNotFound
+// only if neither tier has the thumbnail.
+func (c *TieredCache) ExtendThumbnail(videoID string, t *Thumbnail) error {
+	coldErr := c.cold.ExtendThumbnail(videoID, t)
+	hotErr := c.hot.ExtendThumbnail(videoID, t)
+	if hotErr != nil && !errors.Is(hotErr, ErrNotFound) {
+		slog.Error("failed to extend thumbnail in hot tier", "video_id", videoID, "error", hotErr)
+	}
+	if errors.Is(coldErr, ErrNotFound) && hotErr == nil {
+		return nil
+	}
+	return coldErr
+}
+
+// IsNotFound implements Cache.
+func (c *TieredCache) IsNotFound(videoID string) (bool, error) {
+	notFound, err := c.hot.IsNotFound(videoID)
+	if err != nil {
+		slog.Error("failed to check not found video in hot tier", "video_id", videoID, "error", err)
+	}
+	if notFound {
+		return true, nil
+	}
+	return c.cold.IsNotFound(videoID)
+}
+
+// SetNotFound implements Cache.
+func (c *TieredCache) SetNotFound(videoID string, expiration time.Time) error {
+	if err := c.cold.SetNotFound(videoID, expiration); err != nil {
+		return err
+	}
+	if err := c.hot.SetNotFound(videoID, expiration); err != nil {
+		slog.Error("failed to set not found video in hot tier", "video_id", videoID, "error", err)
+	}
+	return nil
+}
+
+// ClearNotFound implements Cache. It returns the number of videos removed from
+// the cold tier.
+func (c *TieredCache) ClearNotFound() (int64, error) {
+	if _, err := c.hot.ClearNotFound(); err != nil {
+		return 0, err
+	}
+	return c.cold.ClearNotFound()
+}
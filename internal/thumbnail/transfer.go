@@ -0,0 +1,139 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// transfer is a thumbnail whose data may still be downloading. The data is
+// appended as it arrives from upstream and can be read concurrently by any
+// number of readers, including readers that start mid-flight.
+type transfer struct {
+	// t is the thumbnail without data. It's set before the transfer is
+	// shared and never changes.
+	t Thumbnail
+	// url is the URL the thumbnail is downloaded from.
+	url string
+	// body is the rest of the response body, it's nil if the transfer was
+	// complete on creation.
+	body    io.ReadCloser
+	maxSize int64
+
+	mu   sync.Mutex
+	data []byte
+	done bool
+	err  error
+	// changed is closed and replaced whenever data, done or err change.
+	changed chan struct{}
+}
+
+// newTransfer creates a new transfer of a thumbnail whose first bytes are
+// already read. The rest is read from body by copy, at most maxSize bytes in
+// total, zero maxSize means no limit.
+func newTransfer(t Thumbnail, url string, head []byte, body io.ReadCloser, maxSize int64) *transfer {
+	t.Data = nil
+	return &transfer{t: t, url: url, body: body, maxSize: maxSize, data: head, changed: make(chan struct{})}
+}
+
+// completeTransfer creates a transfer of a thumbnail that is fully downloaded.
+func completeTransfer(t *Thumbnail) *transfer {
+	tr := &transfer{t: *t, data: t.Data, done: true, changed: make(chan struct{})}
+	tr.t.Data = nil
+	return tr
+}
+
+// copy reads the rest of the thumbnail from the response body. Once the whole
+// thumbnail is read, finish is called with it before readers see the end of
+// the data, and its error fails the transfer. A transfer that fails is never
+// passed to finish, so truncated data can't be committed by it.
+func (tr *transfer) copy(finish func(t *Thumbnail) error) error {
+	err := tr.readBody()
+	if closeErr := tr.body.Close(); closeErr != nil {
+		slog.Error("failed to close response body", "error", closeErr)
+	}
+
+	if err == nil {
+		tr.mu.Lock()
+		t := tr.t
+		t.Data = tr.data
+		tr.mu.Unlock()
+		err = finish(&t)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.done, tr.err = true, err
+	tr.notify()
+	return err
+}
+
+// readBody appends the response body to the data until EOF.
+func (tr *transfer) readBody() error {
+	buf := make([]byte, maxChunkSize)
+	for {
+		n, err := tr.body.Read(buf)
+		if n > 0 {
+			tr.mu.Lock()
+			size := int64(len(tr.data) + n)
+			if tr.maxSize <= 0 || size <= tr.maxSize {
+				tr.data = append(tr.data, buf[:n]...)
+				tr.notify()
+			}
+			tr.mu.Unlock()
+			if tr.maxSize > 0 && size > tr.maxSize {
+				return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, tr.maxSize)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// abort abandons a transfer that hasn't been copied.
+func (tr *transfer) abort() {
+	if err := tr.body.Close(); err != nil {
+		slog.Error("failed to close response body", "error", err)
+	}
+}
+
+// notify wakes up the readers waiting for changes. It must be called with mu
+// held.
+func (tr *transfer) notify() {
+	close(tr.changed)
+	tr.changed = make(chan struct{})
+}
+
+// read returns at most n bytes of data starting at a given offset. It waits
+// until the data is available and returns io.EOF once the transfer is complete
+// and all data is read. If the transfer fails, it returns its error.
+func (tr *transfer) read(ctx context.Context, offset, n int) ([]byte, error) {
+	for {
+		tr.mu.Lock()
+		data, done, err, changed := tr.data, tr.done, tr.err, tr.changed
+		tr.mu.Unlock()
+
+		// Data is only appended, so the returned slice never changes.
+		if offset < len(data) && err == nil {
+			return data[offset:min(offset+n, len(data))], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return nil, io.EOF
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
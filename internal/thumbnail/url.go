@@ -1,35 +1,173 @@
 package thumbnail
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
+const (
+	// videoIDLength is the length of a YouTube video ID.
+	videoIDLength = 11
+)
+
+var (
+	// ErrInvalidVideoURL is returned when a video URL is not a supported video URL.
+	ErrInvalidVideoURL = errors.New("invalid video URL")
+	// ErrInvalidVideoID is returned when a video ID is not a valid video ID for its provider.
+	ErrInvalidVideoID = errors.New("invalid video ID")
+)
+
+// youTubeHosts are the hosts that serve YouTube videos under the regular
+// youtube.com URL layout.
+var youTubeHosts = map[string]bool{
+	"youtube.com":              true,
+	"www.youtube.com":          true,
+	"m.youtube.com":            true,
+	"music.youtube.com":        true,
+	"youtube-nocookie.com":     true,
+	"www.youtube-nocookie.com": true,
+}
+
+// youTubeShortHosts are the hosts that serve YouTube videos under the short
+// youtu.be URL layout.
+var youTubeShortHosts = map[string]bool{
+	"youtu.be":     true,
+	"www.youtu.be": true,
+}
+
+// youTubeIDPathPrefixes are the path prefixes on youtube.com that are followed
+// by a video ID, e.g. /shorts/dQw4w9WgXcQ.
+var youTubeIDPathPrefixes = []string{"/shorts/", "/embed/", "/live/", "/v/", "/e/"}
+
 // ParseVideoID extracts a video ID from a YouTube video URL.
+//
+// Regular (youtube.com/watch?v=ID), mobile, music, privacy-enhanced
+// (youtube-nocookie.com), shorts, embed, live, legacy (/v/ID), attribution and
+// short (youtu.be/ID) URLs are supported. The scheme may be omitted.
+//
+// The returned error wraps ErrInvalidVideoURL if the URL is not a supported
+// YouTube video URL and ErrInvalidVideoID if the video ID in the URL is
+// missing or malformed.
 func ParseVideoID(videoURL string) (string, error) {
-	u, err := url.Parse(videoURL)
+	u, err := parseURL(videoURL)
 	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidVideoURL, err)
+	}
+	return youTubeVideoID(u)
+}
+
+// youTubeVideoID extracts a video ID from a parsed YouTube video URL.
+func youTubeVideoID(u *url.URL) (string, error) {
+	var videoID string
+	var err error
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case youTubeHosts[host]:
+		videoID, err = parseYouTubePath(u, true)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidVideoURL, err)
+		}
+	case youTubeShortHosts[host]:
+		videoID = firstPathSegment(u.Path)
+	default:
+		return "", fmt.Errorf("%w: unknown host: %q", ErrInvalidVideoURL, u.Host)
+	}
+
+	if err := ValidateVideoID(videoID); err != nil {
 		return "", err
 	}
+	return videoID, nil
+}
+
+// ValidateVideoID checks that a video ID consists of exactly 11 characters of
+// YouTube's URL-safe base64 alphabet ([A-Za-z0-9_-]). Validated IDs are safe to
+// use as file names and URL path segments.
+//
+// The returned error wraps ErrInvalidVideoID.
+func ValidateVideoID(videoID string) error {
+	if videoID == "" {
+		return fmt.Errorf("%w: video ID is required", ErrInvalidVideoID)
+	}
+	if len(videoID) != videoIDLength {
+		return fmt.Errorf("%w: %q: length must be %d", ErrInvalidVideoID, videoID, videoIDLength)
+	}
+	for i := 0; i < len(videoID); i++ {
+		if !isVideoIDChar(videoID[i]) {
+			return fmt.Errorf("%w: %q: unexpected character at position %d", ErrInvalidVideoID, videoID, i)
+		}
+	}
+	return nil
+}
+
+// isVideoIDChar reports whether c belongs to the video ID alphabet.
+func isVideoIDChar(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_'
+}
+
+// parseURL parses a URL that may be missing its scheme, e.g. youtu.be/ID.
+func parseURL(rawURL string) (*url.URL, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") && !strings.HasPrefix(rawURL, "//") {
+		rawURL = "https://" + rawURL
+	}
+	return url.Parse(rawURL)
+}
 
-	switch u.Host {
-	case "www.youtube.com", "youtube.com":
+// parseYouTubePath extracts a video ID from the path and query of a
+// youtube.com URL. Attribution links are followed only if followAttribution is
+// true so that nested attribution links can't recurse indefinitely.
+func parseYouTubePath(u *url.URL, followAttribution bool) (string, error) {
+	path := strings.TrimSuffix(u.Path, "/")
+
+	switch path {
+	case "/watch":
 		q, err := url.ParseQuery(u.RawQuery)
 		if err != nil {
 			return "", err
 		}
 		return q.Get("v"), nil
-	case "youtu.be":
-		return u.Path[1:], nil
+	case "/attribution_link":
+		if !followAttribution {
+			return "", fmt.Errorf("nested attribution link: %s", u)
+		}
+		q, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "", err
+		}
+		// The target is usually a relative reference such as
+		// /watch?v=ID&feature=share.
+		target, err := url.Parse(q.Get("u"))
+		if err != nil {
+			return "", err
+		}
+		resolved := u.ResolveReference(target)
+		if !youTubeHosts[strings.ToLower(resolved.Hostname())] {
+			return "", fmt.Errorf("unknown attribution link target: %s", resolved)
+		}
+		return parseYouTubePath(resolved, false)
 	}
 
-	return "", fmt.Errorf("unknown video URL: %s", videoURL)
+	for _, prefix := range youTubeIDPathPrefixes {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			return firstPathSegment(rest), nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown video URL path: %s", u.Path)
+}
+
+// firstPathSegment returns the first non-empty segment of a URL path.
+func firstPathSegment(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return segment
 }
 
 // URL returns a URL of a thumbnail for a given YouTube video ID.
 func URL(videoID string) (string, error) {
-	if videoID == "" {
-		return "", fmt.Errorf("video ID is required")
+	if err := ValidateVideoID(videoID); err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", videoID), nil
 }
@@ -1,6 +1,7 @@
 package thumbnail_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/kirillgashkov/assignment-youthumb/internal/thumbnail"
@@ -16,8 +17,63 @@ func TestParseVideoID(t *testing.T) {
 		{name: "www.youtube.com", videoURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
 		{name: "youtube.com", videoURL: "https://youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
 		{name: "youtu.be", videoURL: "https://youtu.be/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "http", videoURL: "http://www.youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "uppercase host", videoURL: "https://WWW.YouTube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "extra query", videoURL: "https://www.youtube.com/watch?feature=share&v=dQw4w9WgXcQ&t=42s", want: "dQw4w9WgXcQ"},
+		{name: "fragment", videoURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=42", want: "dQw4w9WgXcQ"},
+		{name: "m.youtube.com", videoURL: "https://m.youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "music.youtube.com", videoURL: "https://music.youtube.com/watch?v=dQw4w9WgXcQ&list=RDAMVM", want: "dQw4w9WgXcQ"},
+		{name: "youtube-nocookie.com embed", videoURL: "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "youtube-nocookie.com without www", videoURL: "https://youtube-nocookie.com/embed/dQw4w9WgXcQ?rel=0", want: "dQw4w9WgXcQ"},
+		{name: "shorts", videoURL: "https://www.youtube.com/shorts/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "shorts with query", videoURL: "https://youtube.com/shorts/dQw4w9WgXcQ?feature=share", want: "dQw4w9WgXcQ"},
+		{name: "embed", videoURL: "https://www.youtube.com/embed/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "embed with query", videoURL: "https://www.youtube.com/embed/dQw4w9WgXcQ?start=10&autoplay=1", want: "dQw4w9WgXcQ"},
+		{name: "live", videoURL: "https://www.youtube.com/live/dQw4w9WgXcQ?si=abc", want: "dQw4w9WgXcQ"},
+		{name: "v", videoURL: "https://www.youtube.com/v/dQw4w9WgXcQ?version=3", want: "dQw4w9WgXcQ"},
+		{name: "e", videoURL: "https://www.youtube.com/e/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "trailing slash", videoURL: "https://www.youtube.com/shorts/dQw4w9WgXcQ/", want: "dQw4w9WgXcQ"},
+		{
+			name:     "attribution_link",
+			videoURL: "https://www.youtube.com/attribution_link?a=abc&u=%2Fwatch%3Fv%3DdQw4w9WgXcQ%26feature%3Dshare",
+			want:     "dQw4w9WgXcQ",
+		},
+		{
+			name:     "attribution_link absolute",
+			videoURL: "https://youtube.com/attribution_link?u=https%3A%2F%2Fm.youtube.com%2Fwatch%3Fv%3DdQw4w9WgXcQ",
+			want:     "dQw4w9WgXcQ",
+		},
+		{
+			name:     "attribution_link to unknown host",
+			videoURL: "https://youtube.com/attribution_link?u=https%3A%2F%2Fexample.com%2Fwatch%3Fv%3DdQw4w9WgXcQ",
+			wantErr:  true,
+		},
+		{
+			name:     "nested attribution_link",
+			videoURL: "https://youtube.com/attribution_link?u=%2Fattribution_link%3Fu%3D%252Fwatch%253Fv%253DdQw4w9WgXcQ",
+			wantErr:  true,
+		},
+		{name: "scheme-less", videoURL: "www.youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "scheme-less without www", videoURL: "youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "scheme-less youtu.be", videoURL: "youtu.be/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "scheme-relative", videoURL: "//www.youtube.com/watch?v=dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "surrounding spaces", videoURL: "  https://youtu.be/dQw4w9WgXcQ\t", want: "dQw4w9WgXcQ"},
+		{name: "youtu.be with query", videoURL: "https://youtu.be/dQw4w9WgXcQ?si=B_RZg_I-lLaa7UU-&t=42", want: "dQw4w9WgXcQ"},
+		{name: "youtu.be with trailing slash", videoURL: "https://youtu.be/dQw4w9WgXcQ/", want: "dQw4w9WgXcQ"},
+		{name: "www.youtu.be", videoURL: "https://www.youtu.be/dQw4w9WgXcQ", want: "dQw4w9WgXcQ"},
+		{name: "unknown path", videoURL: "https://www.youtube.com/feed/trending", wantErr: true},
 		{name: "invalid", videoURL: "https://example.com/watch?v=dQw4w9WgXcQ", wantErr: true},
+		{name: "lookalike host", videoURL: "https://youtube.com.example.com/watch?v=dQw4w9WgXcQ", wantErr: true},
 		{name: "empty", videoURL: "", wantErr: true},
+		{name: "watch without v", videoURL: "https://www.youtube.com/watch", wantErr: true},
+		{name: "watch with empty v", videoURL: "https://www.youtube.com/watch?v=", wantErr: true},
+		{name: "youtu.be without ID", videoURL: "https://youtu.be/", wantErr: true},
+		{name: "youtu.be path traversal", videoURL: "https://youtu.be/../../x", wantErr: true},
+		{name: "youtu.be encoded path traversal", videoURL: "https://youtu.be/%2E%2E%2F%2E%2E%2Fx", wantErr: true},
+		{name: "short ID", videoURL: "https://www.youtube.com/watch?v=dQw4w9WgXc", wantErr: true},
+		{name: "long ID", videoURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQQ", wantErr: true},
+		{name: "ID with invalid character", videoURL: "https://www.youtube.com/watch?v=dQw4w9WgX.Q", wantErr: true},
+		{name: "ID with slash", videoURL: "https://www.youtube.com/watch?v=dQw4w/../cQ", wantErr: true},
 	}
 
 	for _, tt := range tests {
@@ -34,6 +90,86 @@ func TestParseVideoID(t *testing.T) {
 	}
 }
 
+func TestParseVideoIDErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		videoURL string
+		want     error
+	}{
+		{name: "unknown host", videoURL: "https://example.com/watch?v=dQw4w9WgXcQ", want: thumbnail.ErrInvalidVideoURL},
+		{name: "unknown path", videoURL: "https://www.youtube.com/feed/trending", want: thumbnail.ErrInvalidVideoURL},
+		{name: "malformed URL", videoURL: "https://www.youtube.com/watch?v=%zz", want: thumbnail.ErrInvalidVideoURL},
+		{name: "missing ID", videoURL: "https://www.youtube.com/watch", want: thumbnail.ErrInvalidVideoID},
+		{name: "malformed ID", videoURL: "https://youtu.be/../../x", want: thumbnail.ErrInvalidVideoID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := thumbnail.ParseVideoID(tt.videoURL)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ParseVideoID() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseVideoID(f *testing.F) {
+	seeds := []string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://youtu.be/dQw4w9WgXcQ?si=abc",
+		"https://www.youtube.com/shorts/dQw4w9WgXcQ",
+		"https://youtube.com/attribution_link?u=%2Fwatch%3Fv%3DdQw4w9WgXcQ",
+		"youtu.be/../../x",
+		"https://youtu.be/%2E%2E%2F%2E%2E%2Fx",
+		"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, videoURL string) {
+		videoID, err := thumbnail.ParseVideoID(videoURL)
+		if err != nil {
+			if !errors.Is(err, thumbnail.ErrInvalidVideoURL) && !errors.Is(err, thumbnail.ErrInvalidVideoID) {
+				t.Fatalf("ParseVideoID(%q) error = %v, want ErrInvalidVideoURL or ErrInvalidVideoID", videoURL, err)
+			}
+			return
+		}
+		if err := thumbnail.ValidateVideoID(videoID); err != nil {
+			t.Fatalf("ParseVideoID(%q) = %q, which is not a valid video ID: %v", videoURL, videoID, err)
+		}
+	})
+}
+
+func TestValidateVideoID(t *testing.T) {
+	tests := []struct {
+		name    string
+		videoID string
+		wantErr bool
+	}{
+		{name: "valid", videoID: "dQw4w9WgXcQ"},
+		{name: "valid with dash and underscore", videoID: "a-b_c-d_e-f"},
+		{name: "empty", videoID: "", wantErr: true},
+		{name: "too short", videoID: "dQw4w9WgXc", wantErr: true},
+		{name: "too long", videoID: "dQw4w9WgXcQQ", wantErr: true},
+		{name: "dot dot", videoID: "..", wantErr: true},
+		{name: "separator", videoID: "dQw4w/9WgXc", wantErr: true},
+		{name: "non-ASCII", videoID: "dQw4w9WgXcÜ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := thumbnail.ValidateVideoID(tt.videoID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateVideoID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, thumbnail.ErrInvalidVideoID) {
+				t.Errorf("ValidateVideoID() error = %v, want ErrInvalidVideoID", err)
+			}
+		})
+	}
+}
+
 func TestURL(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -43,6 +179,7 @@ func TestURL(t *testing.T) {
 	}{
 		{name: "valid", videoID: "dQw4w9WgXcQ", want: "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg"},
 		{name: "empty", videoID: "", wantErr: true},
+		{name: "path traversal", videoID: "../../x", wantErr: true},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,135 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// VimeoOEmbedURL is the default Vimeo oEmbed endpoint.
+	VimeoOEmbedURL = "https://vimeo.com/api/oembed.json"
+	// vimeoThumbnailHost is the host of Vimeo thumbnails.
+	vimeoThumbnailHost = "i.vimeocdn.com"
+
+	// maxVimeoVideoIDLength is the max length of a Vimeo video ID.
+	maxVimeoVideoIDLength = 20
+	// maxVimeoHashLength is the max length of the hash of an unlisted Vimeo
+	// video.
+	maxVimeoHashLength = 20
+)
+
+// Vimeo is the Vimeo provider. Its thumbnail URLs are resolved via oEmbed.
+type Vimeo struct {
+	// OEmbedURL is the oEmbed endpoint used to resolve thumbnail URLs.
+	OEmbedURL string
+}
+
+// NewVimeo creates a new Vimeo provider that uses the default oEmbed endpoint.
+func NewVimeo() *Vimeo {
+	return &Vimeo{OEmbedURL: VimeoOEmbedURL}
+}
+
+// Name implements Provider.
+func (*Vimeo) Name() string {
+	return "vimeo"
+}
+
+// Match implements Provider.
+func (*Vimeo) Match(u *url.URL) bool {
+	switch strings.ToLower(u.Hostname()) {
+	case "vimeo.com", "www.vimeo.com", "player.vimeo.com":
+		return true
+	}
+	return false
+}
+
+// VideoID implements Provider.
+//
+// Regular (vimeo.com/ID), channel (vimeo.com/channels/NAME/ID), group
+// (vimeo.com/groups/NAME/videos/ID), album and showcase
+// (vimeo.com/showcase/NAME/video/ID) and player (player.vimeo.com/video/ID)
+// URLs are supported.
+//
+// Unlisted videos are only known by their ID together with their hash
+// (vimeo.com/ID/HASH or player.vimeo.com/video/ID?h=HASH), so the video ID of
+// an unlisted video is "ID/HASH".
+func (*Vimeo) VideoID(u *url.URL) (string, error) {
+	segments := pathSegments(u.Path)
+
+	var videoID, hash string
+	switch {
+	case strings.EqualFold(u.Hostname(), "player.vimeo.com") && len(segments) == 2 && segments[0] == "video":
+		videoID = segments[1]
+		hash = u.Query().Get("h")
+	case (len(segments) == 1 || len(segments) == 2) && isDigits(segments[0]):
+		videoID = segments[0]
+		if len(segments) == 2 {
+			hash = segments[1]
+		}
+	case len(segments) == 3 && segments[0] == "channels":
+		videoID = segments[2]
+	case len(segments) == 4 && segments[0] == "groups" && segments[2] == "videos":
+		videoID = segments[3]
+	case len(segments) == 4 && (segments[0] == "album" || segments[0] == "showcase") && segments[2] == "video":
+		videoID = segments[3]
+	default:
+		return "", fmt.Errorf("%w: unknown Vimeo URL path: %s", ErrInvalidVideoURL, u.Path)
+	}
+
+	if videoID == "" || len(videoID) > maxVimeoVideoIDLength || !isDigits(videoID) {
+		return "", fmt.Errorf("%w: %q: Vimeo video ID must be numeric", ErrInvalidVideoID, videoID)
+	}
+	if hash == "" {
+		return videoID, nil
+	}
+	if len(hash) > maxVimeoHashLength || !isHex(hash) {
+		return "", fmt.Errorf("%w: %q: Vimeo video hash must be hexadecimal", ErrInvalidVideoID, hash)
+	}
+	return videoID + "/" + hash, nil
+}
+
+// ThumbnailURL implements Provider. The video ID may include the hash of an
+// unlisted video, see VideoID.
+func (v *Vimeo) ThumbnailURL(ctx context.Context, fetcher *Fetcher, videoID string) (string, error) {
+	return oEmbedThumbnailURL(ctx, fetcher, v.OEmbedURL, "https://vimeo.com/"+videoID, "https", []string{vimeoThumbnailHost})
+}
+
+// pathSegments returns the non-empty segments of a URL path.
+func pathSegments(path string) []string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// isHex reports whether s is a non-empty string of lowercase hexadecimal
+// digits.
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
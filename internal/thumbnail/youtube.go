@@ -0,0 +1,91 @@
+package thumbnail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// YouTubeURLTemplatePlaceholder is replaced by a video ID in a YouTube thumbnail
+// URL template.
+const YouTubeURLTemplatePlaceholder = "{video_id}"
+
+// YouTube serves a gray placeholder instead of the thumbnails of some missing
+// or private videos. The placeholder is as large as the smallest thumbnail,
+// "default.jpg", while every other thumbnail is larger.
+const (
+	youTubePlaceholderWidth  = 120
+	youTubePlaceholderHeight = 90
+)
+
+// YouTube is the YouTube provider. Its thumbnail URLs are built directly from
+// video IDs, see URL.
+type YouTube struct {
+	// URLTemplate is the thumbnail URL template with the
+	// YouTubeURLTemplatePlaceholder in place of the video ID. If it's empty,
+	// URL is used.
+	URLTemplate string
+	// PlaceholderHashes are the hex-encoded SHA-256 hashes of the known
+	// placeholder images, see IsPlaceholder.
+	PlaceholderHashes []string
+}
+
+// NewYouTube creates a new YouTube provider that uses the default thumbnail
+// URL.
+func NewYouTube() *YouTube {
+	return &YouTube{}
+}
+
+// Name implements Provider.
+func (*YouTube) Name() string {
+	return "youtube"
+}
+
+// Match implements Provider.
+func (*YouTube) Match(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	return youTubeHosts[host] || youTubeShortHosts[host]
+}
+
+// VideoID implements Provider. See ParseVideoID for the supported URLs.
+func (*YouTube) VideoID(u *url.URL) (string, error) {
+	return youTubeVideoID(u)
+}
+
+// ThumbnailURL implements Provider.
+func (y *YouTube) ThumbnailURL(_ context.Context, _ *Fetcher, videoID string) (string, error) {
+	if y.URLTemplate == "" {
+		return URL(videoID)
+	}
+	if err := ValidateVideoID(videoID); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(y.URLTemplate, YouTubeURLTemplatePlaceholder, videoID), nil
+}
+
+// IsPlaceholder implements PlaceholderDetector. A thumbnail is a placeholder if
+// its hash is one of PlaceholderHashes or if it's as large as the placeholder
+// while the URL isn't of the smallest thumbnail.
+func (y *YouTube) IsPlaceholder(thumbnailURL string, t *Thumbnail) bool {
+	if len(y.PlaceholderHashes) > 0 {
+		sum := sha256.Sum256(t.Data)
+		hash := hex.EncodeToString(sum[:])
+		if slices.ContainsFunc(y.PlaceholderHashes, func(h string) bool { return strings.EqualFold(h, hash) }) {
+			return true
+		}
+	}
+
+	if t.Width != youTubePlaceholderWidth || t.Height != youTubePlaceholderHeight {
+		return false
+	}
+	u, err := url.Parse(thumbnailURL)
+	if err != nil {
+		return false
+	}
+	name := path.Base(u.Path)
+	return name != "default.jpg" && name != "default.webp"
+}
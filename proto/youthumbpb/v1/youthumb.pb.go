@@ -20,6 +20,69 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// CacheStatus tells how the server obtained a thumbnail.
+type CacheStatus int32
+
+const (
+	CacheStatus_CACHE_STATUS_UNSPECIFIED CacheStatus = 0
+	// CACHE_STATUS_HIT means the thumbnail was fresh in the cache.
+	CacheStatus_CACHE_STATUS_HIT CacheStatus = 1
+	// CACHE_STATUS_MISS means the thumbnail was downloaded from upstream.
+	CacheStatus_CACHE_STATUS_MISS CacheStatus = 2
+	// CACHE_STATUS_REVALIDATED means the thumbnail had expired in the cache and
+	// upstream confirmed it hadn't changed.
+	CacheStatus_CACHE_STATUS_REVALIDATED CacheStatus = 3
+	// CACHE_STATUS_STALE means the thumbnail had expired in the cache and was
+	// served without confirming it with upstream, either while it's refreshed
+	// in the background or because upstream failed.
+	CacheStatus_CACHE_STATUS_STALE CacheStatus = 4
+)
+
+// Enum value maps for CacheStatus.
+var (
+	CacheStatus_name = map[int32]string{
+		0: "CACHE_STATUS_UNSPECIFIED",
+		1: "CACHE_STATUS_HIT",
+		2: "CACHE_STATUS_MISS",
+		3: "CACHE_STATUS_REVALIDATED",
+		4: "CACHE_STATUS_STALE",
+	}
+	CacheStatus_value = map[string]int32{
+		"CACHE_STATUS_UNSPECIFIED": 0,
+		"CACHE_STATUS_HIT":         1,
+		"CACHE_STATUS_MISS":        2,
+		"CACHE_STATUS_REVALIDATED": 3,
+		"CACHE_STATUS_STALE":       4,
+	}
+)
+
+func (x CacheStatus) Enum() *CacheStatus {
+	p := new(CacheStatus)
+	*p = x
+	return p
+}
+
+func (x CacheStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CacheStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_youthumb_v1_youthumb_proto_enumTypes[0].Descriptor()
+}
+
+func (CacheStatus) Type() protoreflect.EnumType {
+	return &file_youthumb_v1_youthumb_proto_enumTypes[0]
+}
+
+func (x CacheStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CacheStatus.Descriptor instead.
+func (CacheStatus) EnumDescriptor() ([]byte, []int) {
+	return file_youthumb_v1_youthumb_proto_rawDescGZIP(), []int{0}
+}
+
 // GetThumbnailRequest represents a request to get a thumbnail of a video.
 type GetThumbnailRequest struct {
 	state         protoimpl.MessageState
@@ -70,7 +133,10 @@ func (x *GetThumbnailRequest) GetVideoUrl() string {
 }
 
 // ThumbnailChunk represents a chunk of thumbnail data. The content type is
-// a MIME type of the data and is sent only once in the first message.
+// a MIME type of the data and is sent only once in the first message, as is
+// the cache status. Chunks of a thumbnail that is still downloading are sent
+// as they arrive, so the stream may fail after some chunks are sent, in which
+// case the received data must be discarded.
 type ThumbnailChunk struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -80,6 +146,8 @@ type ThumbnailChunk struct {
 	ContentType string `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
 	// data is a chunk of thumbnail data.
 	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// cache_status tells how the server obtained the thumbnail.
+	CacheStatus CacheStatus `protobuf:"varint,3,opt,name=cache_status,json=cacheStatus,proto3,enum=youthumb.v1.CacheStatus" json:"cache_status,omitempty"`
 }
 
 func (x *ThumbnailChunk) Reset() {
@@ -128,6 +196,147 @@ func (x *ThumbnailChunk) GetData() []byte {
 	return nil
 }
 
+func (x *ThumbnailChunk) GetCacheStatus() CacheStatus {
+	if x != nil {
+		return x.CacheStatus
+	}
+	return CacheStatus_CACHE_STATUS_UNSPECIFIED
+}
+
+// GetPlaylistThumbnailsRequest represents a request to get thumbnails of the
+// videos of a playlist or a channel.
+type GetPlaylistThumbnailsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// playlist_url is a URL of the playlist or the channel for whose videos
+	// thumbnails should be sent.
+	PlaylistUrl string `protobuf:"bytes,1,opt,name=playlist_url,json=playlistUrl,proto3" json:"playlist_url,omitempty"`
+}
+
+func (x *GetPlaylistThumbnailsRequest) Reset() {
+	*x = GetPlaylistThumbnailsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_youthumb_v1_youthumb_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetPlaylistThumbnailsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPlaylistThumbnailsRequest) ProtoMessage() {}
+
+func (x *GetPlaylistThumbnailsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_youthumb_v1_youthumb_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPlaylistThumbnailsRequest.ProtoReflect.Descriptor instead.
+func (*GetPlaylistThumbnailsRequest) Descriptor() ([]byte, []int) {
+	return file_youthumb_v1_youthumb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetPlaylistThumbnailsRequest) GetPlaylistUrl() string {
+	if x != nil {
+		return x.PlaylistUrl
+	}
+	return ""
+}
+
+// PlaylistThumbnailChunk represents a chunk of thumbnail data of a video of a
+// playlist. If the thumbnail of the video can't be sent, a single message with
+// a non-zero error_code is sent for the video instead. The message may follow
+// chunks of the video if its download fails midway, in which case the chunks
+// must be discarded.
+type PlaylistThumbnailChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// video_url is a URL of the video the chunk belongs to. It is sent in every
+	// message.
+	VideoUrl string `protobuf:"bytes,1,opt,name=video_url,json=videoUrl,proto3" json:"video_url,omitempty"`
+	// chunk is a chunk of the thumbnail of the video.
+	Chunk *ThumbnailChunk `protobuf:"bytes,2,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	// error_code is a gRPC status code of the error that occurred while getting
+	// the thumbnail of the video.
+	ErrorCode int32 `protobuf:"varint,3,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	// error_message is a gRPC status message of the error that occurred while
+	// getting the thumbnail of the video.
+	ErrorMessage string `protobuf:"bytes,4,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+}
+
+func (x *PlaylistThumbnailChunk) Reset() {
+	*x = PlaylistThumbnailChunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_youthumb_v1_youthumb_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PlaylistThumbnailChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlaylistThumbnailChunk) ProtoMessage() {}
+
+func (x *PlaylistThumbnailChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_youthumb_v1_youthumb_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlaylistThumbnailChunk.ProtoReflect.Descriptor instead.
+func (*PlaylistThumbnailChunk) Descriptor() ([]byte, []int) {
+	return file_youthumb_v1_youthumb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PlaylistThumbnailChunk) GetVideoUrl() string {
+	if x != nil {
+		return x.VideoUrl
+	}
+	return ""
+}
+
+func (x *PlaylistThumbnailChunk) GetChunk() *ThumbnailChunk {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+func (x *PlaylistThumbnailChunk) GetErrorCode() int32 {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return 0
+}
+
+func (x *PlaylistThumbnailChunk) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
 var File_youthumb_v1_youthumb_proto protoreflect.FileDescriptor
 
 var file_youthumb_v1_youthumb_proto_rawDesc = []byte{
@@ -136,24 +345,58 @@ var file_youthumb_v1_youthumb_proto_rawDesc = []byte{
 	0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x22, 0x32, 0x0a, 0x13, 0x47, 0x65, 0x74,
 	0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
 	0x12, 0x1b, 0x0a, 0x09, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x5f, 0x75, 0x72, 0x6c, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x08, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x55, 0x72, 0x6c, 0x22, 0x47, 0x0a,
-	0x0e, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12,
-	0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x54, 0x79,
-	0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x32, 0x63, 0x0a, 0x10, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e,
-	0x61, 0x69, 0x6c, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4f, 0x0a, 0x0c, 0x47, 0x65,
-	0x74, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x12, 0x20, 0x2e, 0x79, 0x6f, 0x75,
-	0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x54, 0x68, 0x75, 0x6d,
-	0x62, 0x6e, 0x61, 0x69, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x79,
-	0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x68, 0x75, 0x6d, 0x62,
-	0x6e, 0x61, 0x69, 0x6c, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x30, 0x01, 0x42, 0x4d, 0x5a, 0x4b, 0x67,
-	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6b, 0x69, 0x72, 0x69, 0x6c, 0x6c,
-	0x67, 0x61, 0x73, 0x68, 0x6b, 0x6f, 0x76, 0x2f, 0x61, 0x73, 0x73, 0x69, 0x67, 0x6e, 0x6d, 0x65,
-	0x6e, 0x74, 0x2d, 0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2f, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x2f, 0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x70, 0x62, 0x2f, 0x76, 0x31, 0x3b,
-	0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x33,
+	0x01, 0x28, 0x09, 0x52, 0x08, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x55, 0x72, 0x6c, 0x22, 0x84, 0x01,
+	0x0a, 0x0e, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x43, 0x68, 0x75, 0x6e, 0x6b,
+	0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x54,
+	0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x3b, 0x0a, 0x0c, 0x63, 0x61, 0x63, 0x68, 0x65,
+	0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x18, 0x2e,
+	0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x0b, 0x63, 0x61, 0x63, 0x68, 0x65, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x22, 0x41, 0x0a, 0x1c, 0x47, 0x65, 0x74, 0x50, 0x6c, 0x61, 0x79, 0x6c,
+	0x69, 0x73, 0x74, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x6c, 0x61, 0x79, 0x6c, 0x69, 0x73, 0x74,
+	0x5f, 0x75, 0x72, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x70, 0x6c, 0x61, 0x79,
+	0x6c, 0x69, 0x73, 0x74, 0x55, 0x72, 0x6c, 0x22, 0xac, 0x01, 0x0a, 0x16, 0x50, 0x6c, 0x61, 0x79,
+	0x6c, 0x69, 0x73, 0x74, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x43, 0x68, 0x75,
+	0x6e, 0x6b, 0x12, 0x1b, 0x0a, 0x09, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x5f, 0x75, 0x72, 0x6c, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x76, 0x69, 0x64, 0x65, 0x6f, 0x55, 0x72, 0x6c, 0x12,
+	0x31, 0x0a, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b,
+	0x2e, 0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x68, 0x75,
+	0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x52, 0x05, 0x63, 0x68, 0x75,
+	0x6e, 0x6b, 0x12, 0x1d, 0x0a, 0x0a, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x64, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64,
+	0x65, 0x12, 0x23, 0x0a, 0x0d, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2a, 0x8e, 0x01, 0x0a, 0x0b, 0x43, 0x61, 0x63, 0x68, 0x65,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x1c, 0x0a, 0x18, 0x43, 0x41, 0x43, 0x48, 0x45, 0x5f,
+	0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49,
+	0x45, 0x44, 0x10, 0x00, 0x12, 0x14, 0x0a, 0x10, 0x43, 0x41, 0x43, 0x48, 0x45, 0x5f, 0x53, 0x54,
+	0x41, 0x54, 0x55, 0x53, 0x5f, 0x48, 0x49, 0x54, 0x10, 0x01, 0x12, 0x15, 0x0a, 0x11, 0x43, 0x41,
+	0x43, 0x48, 0x45, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x4d, 0x49, 0x53, 0x53, 0x10,
+	0x02, 0x12, 0x1c, 0x0a, 0x18, 0x43, 0x41, 0x43, 0x48, 0x45, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x55,
+	0x53, 0x5f, 0x52, 0x45, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x41, 0x54, 0x45, 0x44, 0x10, 0x03, 0x12,
+	0x16, 0x0a, 0x12, 0x43, 0x41, 0x43, 0x48, 0x45, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f,
+	0x53, 0x54, 0x41, 0x4c, 0x45, 0x10, 0x04, 0x32, 0xce, 0x01, 0x0a, 0x10, 0x54, 0x68, 0x75, 0x6d,
+	0x62, 0x6e, 0x61, 0x69, 0x6c, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4f, 0x0a, 0x0c,
+	0x47, 0x65, 0x74, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x12, 0x20, 0x2e, 0x79,
+	0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x54, 0x68,
+	0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b,
+	0x2e, 0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x68, 0x75,
+	0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x30, 0x01, 0x12, 0x69, 0x0a,
+	0x15, 0x47, 0x65, 0x74, 0x50, 0x6c, 0x61, 0x79, 0x6c, 0x69, 0x73, 0x74, 0x54, 0x68, 0x75, 0x6d,
+	0x62, 0x6e, 0x61, 0x69, 0x6c, 0x73, 0x12, 0x29, 0x2e, 0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d,
+	0x62, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x6c, 0x61, 0x79, 0x6c, 0x69, 0x73, 0x74,
+	0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x1a, 0x23, 0x2e, 0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2e, 0x76, 0x31, 0x2e,
+	0x50, 0x6c, 0x61, 0x79, 0x6c, 0x69, 0x73, 0x74, 0x54, 0x68, 0x75, 0x6d, 0x62, 0x6e, 0x61, 0x69,
+	0x6c, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x30, 0x01, 0x42, 0x4d, 0x5a, 0x4b, 0x67, 0x69, 0x74, 0x68,
+	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6b, 0x69, 0x72, 0x69, 0x6c, 0x6c, 0x67, 0x61, 0x73,
+	0x68, 0x6b, 0x6f, 0x76, 0x2f, 0x61, 0x73, 0x73, 0x69, 0x67, 0x6e, 0x6d, 0x65, 0x6e, 0x74, 0x2d,
+	0x79, 0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x79,
+	0x6f, 0x75, 0x74, 0x68, 0x75, 0x6d, 0x62, 0x70, 0x62, 0x2f, 0x76, 0x31, 0x3b, 0x79, 0x6f, 0x75,
+	0x74, 0x68, 0x75, 0x6d, 0x62, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -168,19 +411,27 @@ func file_youthumb_v1_youthumb_proto_rawDescGZIP() []byte {
 	return file_youthumb_v1_youthumb_proto_rawDescData
 }
 
-var file_youthumb_v1_youthumb_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_youthumb_v1_youthumb_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_youthumb_v1_youthumb_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_youthumb_v1_youthumb_proto_goTypes = []any{
-	(*GetThumbnailRequest)(nil), // 0: youthumb.v1.GetThumbnailRequest
-	(*ThumbnailChunk)(nil),      // 1: youthumb.v1.ThumbnailChunk
+	(CacheStatus)(0),                     // 0: youthumb.v1.CacheStatus
+	(*GetThumbnailRequest)(nil),          // 1: youthumb.v1.GetThumbnailRequest
+	(*ThumbnailChunk)(nil),               // 2: youthumb.v1.ThumbnailChunk
+	(*GetPlaylistThumbnailsRequest)(nil), // 3: youthumb.v1.GetPlaylistThumbnailsRequest
+	(*PlaylistThumbnailChunk)(nil),       // 4: youthumb.v1.PlaylistThumbnailChunk
 }
 var file_youthumb_v1_youthumb_proto_depIdxs = []int32{
-	0, // 0: youthumb.v1.ThumbnailService.GetThumbnail:input_type -> youthumb.v1.GetThumbnailRequest
-	1, // 1: youthumb.v1.ThumbnailService.GetThumbnail:output_type -> youthumb.v1.ThumbnailChunk
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: youthumb.v1.ThumbnailChunk.cache_status:type_name -> youthumb.v1.CacheStatus
+	2, // 1: youthumb.v1.PlaylistThumbnailChunk.chunk:type_name -> youthumb.v1.ThumbnailChunk
+	1, // 2: youthumb.v1.ThumbnailService.GetThumbnail:input_type -> youthumb.v1.GetThumbnailRequest
+	3, // 3: youthumb.v1.ThumbnailService.GetPlaylistThumbnails:input_type -> youthumb.v1.GetPlaylistThumbnailsRequest
+	2, // 4: youthumb.v1.ThumbnailService.GetThumbnail:output_type -> youthumb.v1.ThumbnailChunk
+	4, // 5: youthumb.v1.ThumbnailService.GetPlaylistThumbnails:output_type -> youthumb.v1.PlaylistThumbnailChunk
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_youthumb_v1_youthumb_proto_init() }
@@ -213,19 +464,44 @@ func file_youthumb_v1_youthumb_proto_init() {
 				return nil
 			}
 		}
+		file_youthumb_v1_youthumb_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*GetPlaylistThumbnailsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_youthumb_v1_youthumb_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*PlaylistThumbnailChunk); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_youthumb_v1_youthumb_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      1,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_youthumb_v1_youthumb_proto_goTypes,
 		DependencyIndexes: file_youthumb_v1_youthumb_proto_depIdxs,
+		EnumInfos:         file_youthumb_v1_youthumb_proto_enumTypes,
 		MessageInfos:      file_youthumb_v1_youthumb_proto_msgTypes,
 	}.Build()
 	File_youthumb_v1_youthumb_proto = out.File
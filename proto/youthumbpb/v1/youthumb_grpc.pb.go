@@ -19,7 +19,8 @@ import (
 const _ = grpc.SupportPackageIsVersion8
 
 const (
-	ThumbnailService_GetThumbnail_FullMethodName = "/youthumb.v1.ThumbnailService/GetThumbnail"
+	ThumbnailService_GetThumbnail_FullMethodName          = "/youthumb.v1.ThumbnailService/GetThumbnail"
+	ThumbnailService_GetPlaylistThumbnails_FullMethodName = "/youthumb.v1.ThumbnailService/GetPlaylistThumbnails"
 )
 
 // ThumbnailServiceClient is the client API for ThumbnailService service.
@@ -32,6 +33,11 @@ type ThumbnailServiceClient interface {
 	// GetThumbnail returns a stream of ThumbnailChunk messages that represent
 	// a thumbnail of the video at the given URL.
 	GetThumbnail(ctx context.Context, in *GetThumbnailRequest, opts ...grpc.CallOption) (ThumbnailService_GetThumbnailClient, error)
+	// GetPlaylistThumbnails expands the playlist or channel at the given URL
+	// into its videos and returns a stream of PlaylistThumbnailChunk messages
+	// that represent thumbnails of the videos. Thumbnails are sent one after
+	// another, chunks of different videos are never interleaved.
+	GetPlaylistThumbnails(ctx context.Context, in *GetPlaylistThumbnailsRequest, opts ...grpc.CallOption) (ThumbnailService_GetPlaylistThumbnailsClient, error)
 }
 
 type thumbnailServiceClient struct {
@@ -75,6 +81,39 @@ func (x *thumbnailServiceGetThumbnailClient) Recv() (*ThumbnailChunk, error) {
 	return m, nil
 }
 
+func (c *thumbnailServiceClient) GetPlaylistThumbnails(ctx context.Context, in *GetPlaylistThumbnailsRequest, opts ...grpc.CallOption) (ThumbnailService_GetPlaylistThumbnailsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ThumbnailService_ServiceDesc.Streams[1], ThumbnailService_GetPlaylistThumbnails_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &thumbnailServiceGetPlaylistThumbnailsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ThumbnailService_GetPlaylistThumbnailsClient interface {
+	Recv() (*PlaylistThumbnailChunk, error)
+	grpc.ClientStream
+}
+
+type thumbnailServiceGetPlaylistThumbnailsClient struct {
+	grpc.ClientStream
+}
+
+func (x *thumbnailServiceGetPlaylistThumbnailsClient) Recv() (*PlaylistThumbnailChunk, error) {
+	m := new(PlaylistThumbnailChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ThumbnailServiceServer is the server API for ThumbnailService service.
 // All implementations must embed UnimplementedThumbnailServiceServer
 // for forward compatibility
@@ -85,6 +124,11 @@ type ThumbnailServiceServer interface {
 	// GetThumbnail returns a stream of ThumbnailChunk messages that represent
 	// a thumbnail of the video at the given URL.
 	GetThumbnail(*GetThumbnailRequest, ThumbnailService_GetThumbnailServer) error
+	// GetPlaylistThumbnails expands the playlist or channel at the given URL
+	// into its videos and returns a stream of PlaylistThumbnailChunk messages
+	// that represent thumbnails of the videos. Thumbnails are sent one after
+	// another, chunks of different videos are never interleaved.
+	GetPlaylistThumbnails(*GetPlaylistThumbnailsRequest, ThumbnailService_GetPlaylistThumbnailsServer) error
 	mustEmbedUnimplementedThumbnailServiceServer()
 }
 
@@ -95,6 +139,9 @@ type UnimplementedThumbnailServiceServer struct {
 func (UnimplementedThumbnailServiceServer) GetThumbnail(*GetThumbnailRequest, ThumbnailService_GetThumbnailServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetThumbnail not implemented")
 }
+func (UnimplementedThumbnailServiceServer) GetPlaylistThumbnails(*GetPlaylistThumbnailsRequest, ThumbnailService_GetPlaylistThumbnailsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetPlaylistThumbnails not implemented")
+}
 func (UnimplementedThumbnailServiceServer) mustEmbedUnimplementedThumbnailServiceServer() {}
 
 // UnsafeThumbnailServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -129,6 +176,27 @@ func (x *thumbnailServiceGetThumbnailServer) Send(m *ThumbnailChunk) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _ThumbnailService_GetPlaylistThumbnails_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetPlaylistThumbnailsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ThumbnailServiceServer).GetPlaylistThumbnails(m, &thumbnailServiceGetPlaylistThumbnailsServer{ServerStream: stream})
+}
+
+type ThumbnailService_GetPlaylistThumbnailsServer interface {
+	Send(*PlaylistThumbnailChunk) error
+	grpc.ServerStream
+}
+
+type thumbnailServiceGetPlaylistThumbnailsServer struct {
+	grpc.ServerStream
+}
+
+func (x *thumbnailServiceGetPlaylistThumbnailsServer) Send(m *PlaylistThumbnailChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // ThumbnailService_ServiceDesc is the grpc.ServiceDesc for ThumbnailService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -142,6 +210,11 @@ var ThumbnailService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _ThumbnailService_GetThumbnail_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetPlaylistThumbnails",
+			Handler:       _ThumbnailService_GetPlaylistThumbnails_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "youthumb/v1/youthumb.proto",
 }